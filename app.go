@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/clintjedwards/goto/config"
 	"github.com/clintjedwards/goto/storage"
@@ -11,47 +12,59 @@ import (
 )
 
 type app struct {
-	config  *config.Config
-	storage storage.Engine
+	// config is swapped out as a whole when settings are reloaded; use currentConfig to read it
+	config     atomic.Pointer[config.Config]
+	configPath string
+	// routeIDs are the short names taken by registered routes
+	routeIDs []string
+	storage  storage.Engine
+	hits     *hitRecorder
+	// networkSalt hashes client networks when no salt is configured
+	networkSalt []byte
 }
 
-func newApp() *app {
-
-	config, err := config.FromEnv()
-	if err != nil {
-		log.Fatal().Err(err).Msg("could not load env config")
-	}
-
-	storage, err := initStorage(storage.EngineType(config.Database.Engine))
+// newApp creates an app from the loaded configuration. configPath is the file the
+// configuration was read from, if any, and is read again whenever settings are reloaded.
+func newApp(config *config.Config, configPath string) *app {
+	storage, err := initStorage(config.Database)
 	if err != nil {
 		log.Fatal().Err(err).Msg("could not configure storage")
 	}
 
-	return &app{
-		config:  config,
-		storage: storage,
+	instrumentedStorage := instrumentStorage(storage, config.Database.Engine)
+
+	app := &app{
+		configPath:  configPath,
+		storage:     instrumentedStorage,
+		hits:        newHitRecorder(instrumentedStorage),
+		networkSalt: newNetworkSalt(),
 	}
+	app.config.Store(config)
+
+	return app
+}
+
+// currentConfig returns the most recently loaded configuration
+func (app *app) currentConfig() *config.Config {
+	return app.config.Load()
 }
 
 // initStorage creates a storage object with the appropriate engine
-func initStorage(engineType storage.EngineType) (storage.Engine, error) {
+func initStorage(config *config.DatabaseConfig) (storage.Engine, error) {
 
-	config, err := config.FromEnv()
-	if err != nil {
-		return nil, err
-	}
+	engineType := storage.EngineType(config.Engine)
 
 	switch engineType {
 	case storage.BoltEngine:
 
-		boltStorageEngine, err := bolt.Init(config.Database.Bolt)
+		boltStorageEngine, err := bolt.Init(config.Bolt)
 		if err != nil {
 			return nil, err
 		}
 
 		return &boltStorageEngine, nil
 	case storage.RedisEngine:
-		redisStorageEngine, err := redis.Init(config.Database.Redis)
+		redisStorageEngine, err := redis.Init(config.Redis)
 		if err != nil {
 			return nil, err
 		}
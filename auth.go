@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	utilErrors "github.com/clintjedwards/goto/errors"
+)
+
+// authenticated only passes requests on to next when they present one of the configured auth
+// tokens as a bearer token. When no tokens are configured every request is let through.
+func (app *app) authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tokens := app.currentConfig().AuthTokens
+		if len(tokens) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		token, found := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if found && validToken(token, tokens) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="goto"`)
+		sendErrResponse(w, req, utilErrors.New(utilErrors.CodeUnauthorized, "a valid auth token is required"))
+	})
+}
+
+// validToken reports whether token matches any of the allowed tokens, comparing each in
+// constant time so that response times don't reveal how much of a token was correct.
+func validToken(token string, allowed []string) bool {
+	valid := false
+	for _, candidate := range allowed {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+			valid = true
+		}
+	}
+
+	return valid
+}
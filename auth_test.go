@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/clintjedwards/goto/config"
+)
+
+func TestAuthenticated(t *testing.T) {
+	tests := map[string]struct {
+		tokens []string
+		header string
+		want   int
+	}{
+		"no tokens configured": {
+			tokens: nil,
+			header: "",
+			want:   http.StatusOK,
+		},
+		"missing token": {
+			tokens: []string{"first", "second"},
+			header: "",
+			want:   http.StatusUnauthorized,
+		},
+		"wrong token": {
+			tokens: []string{"first", "second"},
+			header: "Bearer third",
+			want:   http.StatusUnauthorized,
+		},
+		"valid token": {
+			tokens: []string{"first", "second"},
+			header: "Bearer second",
+			want:   http.StatusOK,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			app := &app{}
+			app.config.Store(&config.Config{AuthTokens: tc.tokens})
+
+			handler := app.authenticated(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/create", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != tc.want {
+				t.Errorf("unexpected status; want %d; got %d", tc.want, recorder.Code)
+			}
+		})
+	}
+}
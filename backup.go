@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/clintjedwards/goto/client"
+	"github.com/clintjedwards/goto/config"
+	"github.com/clintjedwards/goto/storage"
+	"github.com/clintjedwards/goto/storage/bolt"
+	"github.com/rs/zerolog/log"
+)
+
+const backupPrefix = "goto-"
+const backupSuffix = ".db"
+
+// backupFileName returns a name for a snapshot that sorts in the order snapshots were taken
+func backupFileName(t time.Time) string {
+	return backupPrefix + t.UTC().Format("20060102T150405Z") + backupSuffix
+}
+
+// runBackups writes a snapshot of the database to the configured directory on every interval
+// and removes all but the most recent snapshots. It blocks forever and should be run in a goroutine.
+func runBackups(snapshotter storage.Snapshotter, config *config.BackupConfig) {
+	log.Info().Str("dir", config.Dir).Dur("interval", config.Interval).
+		Int("retain", config.Retain).Msg("scheduled backups enabled")
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		path, err := writeBackup(context.Background(), snapshotter, config.Dir)
+		if err != nil {
+			log.Error().Err(err).Msg("could not write scheduled backup")
+			continue
+		}
+		log.Info().Str("path", path).Msg("wrote scheduled backup")
+
+		err = rotateBackups(config.Dir, config.Retain)
+		if err != nil {
+			log.Error().Err(err).Msg("could not rotate backups")
+		}
+	}
+}
+
+// writeBackup snapshots the database into a new file within dir and returns its path.
+// The snapshot is written to a temporary file first so that a partial backup is never mistaken
+// for a complete one.
+func writeBackup(ctx context.Context, snapshotter storage.Snapshotter, dir string) (string, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, backupFileName(time.Now()))
+	tmpPath := path + ".tmp"
+
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = snapshotter.Snapshot(ctx, file)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// rotateBackups removes the oldest snapshots within dir so that only retain remain
+func rotateBackups(dir string, retain int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	backups := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		backups = append(backups, name)
+	}
+
+	if len(backups) <= retain {
+		return nil
+	}
+
+	sort.Strings(backups)
+
+	for _, name := range backups[:len(backups)-retain] {
+		err := os.Remove(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		log.Debug().Str("name", name).Msg("removed old backup")
+	}
+
+	return nil
+}
+
+// runBackup downloads a snapshot from a running server and validates it
+func runBackup(config *config.Config, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	server := flags.String("server", "http://"+config.Host+config.APIPrefix, "address of the goto server, including any api prefix")
+	output := flags.String("output", backupFileName(time.Now()), "file to write the snapshot to")
+	token := flags.String("token", firstOrEmpty(config.AuthTokens), "auth token to present to the server")
+	_ = flags.Parse(args)
+
+	file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	size, err := client.New(*server, client.WithToken(*token)).Backup(context.Background(), file)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*output)
+		return err
+	}
+
+	count, err := bolt.ValidateSnapshot(*output)
+	if err != nil {
+		os.Remove(*output)
+		return fmt.Errorf("downloaded snapshot is invalid: %w", err)
+	}
+
+	log.Info().Str("path", *output).Int64("bytes", size).Int("links", count).Msg("backup complete")
+	return nil
+}
+
+// runRestore replaces the local bolt database with a previously taken snapshot
+func runRestore(config *config.Config, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	snapshot := flags.String("snapshot", "", "snapshot file to restore from")
+	path := flags.String("path", config.Database.Bolt.Path, "bolt database file to replace")
+	_ = flags.Parse(args)
+
+	if *snapshot == "" {
+		flags.Usage()
+		return errors.New("--snapshot must be specified")
+	}
+
+	boltConfig := *config.Database.Bolt
+	boltConfig.Path = *path
+
+	return bolt.Restore(&boltConfig, *snapshot)
+}
+
+func firstOrEmpty(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
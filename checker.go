@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clintjedwards/goto/config"
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+	"github.com/clintjedwards/goto/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// checkerUserAgent identifies the link checker to the sites it checks
+const checkerUserAgent = "goto-link-checker"
+
+// maxCheckBody is the most of a response body read when checking a destination with GET
+const maxCheckBody = 64 * 1024
+
+// linkChecker requests each link's destination to find links that no longer work
+type linkChecker struct {
+	storage storage.Engine
+	config  *config.CheckerConfig
+	client  *http.Client
+}
+
+// maxCheckRedirects is the most redirects followed when checking a destination
+const maxCheckRedirects = 10
+
+func newLinkChecker(storage storage.Engine, config *config.CheckerConfig) *linkChecker {
+	checker := &linkChecker{
+		storage: storage,
+		config:  config,
+	}
+	checker.client = &http.Client{Timeout: config.Timeout, CheckRedirect: checker.checkRedirect}
+	return checker
+}
+
+// checkRedirect stops the checker from being redirected to hosts it isn't allowed to request,
+// such as internal addresses. The redirect itself is taken as the destination's status then,
+// since it says nothing about whether the destination works.
+func (c *linkChecker) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxCheckRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxCheckRedirects)
+	}
+	if !c.allowed(req.URL.String()) {
+		log.Debug().Str("url", via[0].URL.String()).Str("redirect", req.URL.String()).
+			Msg("not following redirect to a host the link checker isn't allowed to request")
+		return http.ErrUseLastResponse
+	}
+	return nil
+}
+
+// run checks every link on each interval. It blocks forever and should be run in a goroutine.
+func (c *linkChecker) run() {
+	log.Info().Dur("interval", c.config.Interval).Int("concurrency", c.config.Concurrency).
+		Dur("fallback_interval", c.config.FallbackInterval).Float64("rate_limit", c.config.RateLimit).
+		Strs("allowed_hosts", c.config.AllowedHosts).Msg("link checker enabled")
+
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+
+	// A nil channel never fires, leaving links with fallbacks to be checked with every other link
+	var fallbacks <-chan time.Time
+	if c.config.FallbackInterval > 0 {
+		fallbackTicker := time.NewTicker(c.config.FallbackInterval)
+		defer fallbackTicker.Stop()
+		fallbacks = fallbackTicker.C
+	}
+
+	// Links are checked straight away so that their health is known without waiting an interval
+	err := c.checkAll(context.Background())
+
+	for {
+		if err != nil {
+			log.Error().Err(err).Msg("could not check links")
+		}
+
+		select {
+		case <-ticker.C:
+			err = c.checkAll(context.Background())
+		case <-fallbacks:
+			err = c.checkFallbacks(context.Background())
+		}
+	}
+}
+
+// checkAll checks the destination of every link that can be checked and records the results.
+// Formatted links are skipped since their destination depends on how they are followed.
+func (c *linkChecker) checkAll(ctx context.Context) error {
+	checked, skipped, err := c.checkWhere(ctx, func(models.Link) bool { return true })
+	log.Info().Int("checked", checked).Int("skipped", skipped).Msg("checked links")
+	return err
+}
+
+// checkFallbacks checks the destinations of links with fallbacks so that they fail over quickly
+func (c *linkChecker) checkFallbacks(ctx context.Context) error {
+	checked, _, err := c.checkWhere(ctx, func(link models.Link) bool { return len(link.Fallbacks) > 0 })
+	log.Debug().Int("checked", checked).Msg("checked links with fallbacks")
+	return err
+}
+
+// checkWhere checks the destination of every link that can be checked and include returns true
+// for, returning how many links were checked and how many weren't
+func (c *linkChecker) checkWhere(ctx context.Context, include func(models.Link) bool) (int, int, error) {
+	links, err := c.storage.GetAllLinks(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	pending := make(chan models.Link)
+
+	// Requests are spread out evenly no matter how many workers are making them
+	limiter := time.NewTicker(time.Duration(float64(time.Second) / c.config.RateLimit))
+	defer limiter.Stop()
+
+	workers := sync.WaitGroup{}
+	for i := 0; i < c.config.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for link := range pending {
+				select {
+				case <-limiter.C:
+				case <-ctx.Done():
+					continue
+				}
+				c.checkLink(ctx, link)
+			}
+		}()
+	}
+
+	checked := 0
+	for _, link := range links {
+		if !include(link) || models.KindOf(link.URL) == models.Formatted || link.Archived != 0 || !c.allowed(link.URL) {
+			continue
+		}
+		pending <- link
+		checked++
+	}
+	close(pending)
+	workers.Wait()
+
+	return checked, len(links) - checked, ctx.Err()
+}
+
+// allowed reports whether destination is on a host the checker may request
+func (c *linkChecker) allowed(destination string) bool {
+	parsed, err := url.Parse(destination)
+	if err != nil || parsed.Hostname() == "" {
+		return false
+	}
+	if len(c.config.AllowedHosts) == 0 {
+		return true
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for _, allowed := range c.config.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed {
+			return true
+		}
+		if suffix, found := strings.CutPrefix(allowed, "*"); found && strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkLink requests a link's destination and records the outcome on the link. For links with
+// fallbacks, it also decides where the link should redirect.
+func (c *linkChecker) checkLink(ctx context.Context, link models.Link) {
+	status, checkErr := c.check(ctx, link.URL)
+	healthy := checkErr == nil && status < 400
+
+	var failover *models.Failover
+	if len(link.Fallbacks) > 0 {
+		failover = c.failover(ctx, link, healthy)
+	}
+
+	err := c.storage.UpdateLink(ctx, link.ID, func(stored *models.Link) error {
+		stored.LastChecked = time.Now().Unix()
+		stored.LastStatus = status
+		stored.CheckError = ""
+		if checkErr != nil {
+			stored.CheckError = checkErr.Error()
+		}
+		stored.Failover = failover
+		return nil
+	})
+	if err != nil && !errors.Is(err, utilErrors.ErrNotFound) {
+		log.Error().Err(err).Str("id", link.ID).Msg("could not record link check")
+		return
+	}
+
+	if checkErr != nil || status >= 400 {
+		log.Debug().Err(checkErr).Int("status", status).Str("id", link.ID).Str("url", link.URL).
+			Msg("link destination is broken")
+	}
+}
+
+// failover decides where a link with fallbacks should redirect: its url when healthy, otherwise
+// the first fallback that is, or its url again when nothing is. Fallbacks are only requested
+// while the url is down and on hosts the checker may request.
+func (c *linkChecker) failover(ctx context.Context, link models.Link, healthy bool) *models.Failover {
+	decision := &models.Failover{URL: link.URL, Healthy: healthy, Decided: time.Now().Unix()}
+
+	for i, fallback := range link.Fallbacks {
+		if healthy {
+			break
+		}
+		if !c.allowed(fallback) {
+			continue
+		}
+
+		status, err := c.check(ctx, fallback)
+		if err != nil || status >= 400 {
+			log.Debug().Err(err).Int("status", status).Str("id", link.ID).Str("url", fallback).
+				Msg("link fallback is broken")
+			continue
+		}
+
+		decision.URL = fallback
+		decision.Fallback = &i
+		decision.Healthy = true
+		break
+	}
+
+	if link.FailoverURL() != decision.URL {
+		log.Info().Str("id", link.ID).Str("from", link.FailoverURL()).Str("to", decision.URL).
+			Bool("healthy", decision.Healthy).Msg("link now redirects to a different destination")
+	}
+
+	return decision
+}
+
+// failedOverLink returns link with its url replaced by the fallback the link checker last chose,
+// if it chose one because the url was down. Only standard links can have fallbacks.
+func failedOverLink(link models.Link) models.Link {
+	destination := link.FailoverURL()
+	if destination == link.URL {
+		return link
+	}
+
+	link.URL = destination
+	link.Kind = models.KindOf(link.URL)
+	return link
+}
+
+// check requests destination with HEAD, falling back to GET for servers that don't handle HEAD
+// properly, and returns the final status after following redirects
+func (c *linkChecker) check(ctx context.Context, destination string) (int, error) {
+	status, err := c.request(ctx, http.MethodHead, destination)
+	if err == nil && status < 400 {
+		return status, nil
+	}
+
+	return c.request(ctx, http.MethodGet, destination)
+}
+
+func (c *linkChecker) request(ctx context.Context, method, destination string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, destination, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", checkerUserAgent)
+
+	response, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	// Reading some of the body lets the connection be reused without downloading large pages
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, maxCheckBody))
+
+	return response.StatusCode, nil
+}
+
+// brokenReportHandler lists links whose destination failed its latest check
+func (app *app) brokenReportHandler(w http.ResponseWriter, req *http.Request) {
+	links, err := app.storage.GetAllLinks(req.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("error retrieving links")
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve links", err))
+		return
+	}
+
+	broken := []models.Link{}
+	for _, link := range links {
+		if link.Broken() {
+			broken = append(broken, link)
+		}
+	}
+	sort.Slice(broken, func(i, j int) bool { return broken[i].ID < broken[j].ID })
+
+	sendResponse(w, http.StatusOK, map[string]interface{}{"links": broken})
+}
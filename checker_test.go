@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clintjedwards/goto/config"
+	"github.com/clintjedwards/goto/models"
+)
+
+func TestLinkChecker(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer ok.Close()
+
+	moved := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/wiki" {
+			http.Redirect(w, req, "/gone", http.StatusMovedPermanently)
+			return
+		}
+		http.NotFound(w, req)
+	}))
+	defer moved.Close()
+
+	noHead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer noHead.Close()
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	down.Close()
+
+	internalRequests := atomic.Int32{}
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		internalRequests.Add(1)
+	}))
+	defer internal.Close()
+
+	// Redirects can't be used to reach hosts the checker isn't allowed to request
+	escape := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, strings.Replace(internal.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+	}))
+	defer escape.Close()
+
+	app := newTestApp(t)
+	links := map[string]string{
+		"escape":    escape.URL,
+		"ok":        ok.URL,
+		"moved":     moved.URL + "/wiki",
+		"nohead":    noHead.URL,
+		"down":      down.URL,
+		"formatted": moved.URL + "/{}",
+		// Requests to hosts that aren't allowed are never made
+		"elsewhere": strings.Replace(moved.URL, "127.0.0.1", "localhost", 1) + "/wiki",
+	}
+	for id, url := range links {
+		link := models.CreateLinkRequest{ID: id, URL: url}.ToLink()
+		if err := app.storage.CreateLink(context.Background(), link); err != nil {
+			t.Fatalf("could not create link: %v", err)
+		}
+	}
+
+	checker := newLinkChecker(app.storage, &config.CheckerConfig{
+		Concurrency:  2,
+		RateLimit:    100,
+		Timeout:      time.Second,
+		AllowedHosts: []string{"127.0.0.1"},
+	})
+	if err := checker.checkAll(context.Background()); err != nil {
+		t.Fatalf("could not check links: %v", err)
+	}
+
+	tests := map[string]struct {
+		checked bool
+		status  int
+		broken  bool
+	}{
+		"ok":        {checked: true, status: http.StatusOK},
+		"escape":    {checked: true, status: http.StatusFound},
+		"moved":     {checked: true, status: http.StatusNotFound, broken: true},
+		"nohead":    {checked: true, status: http.StatusOK},
+		"down":      {checked: true, broken: true},
+		"formatted": {},
+		"elsewhere": {},
+	}
+	for id, want := range tests {
+		link, err := app.storage.GetLink(context.Background(), id)
+		if err != nil {
+			t.Fatalf("could not retrieve link: %v", err)
+		}
+
+		if (link.LastChecked != 0) != want.checked {
+			t.Errorf("%s: unexpected check; want checked %v; got last checked %d", id, want.checked, link.LastChecked)
+		}
+		if link.LastStatus != want.status {
+			t.Errorf("%s: unexpected status; want %d; got %d", id, want.status, link.LastStatus)
+		}
+		if link.Broken() != want.broken {
+			t.Errorf("%s: unexpected broken; want %v; got %v (%s)", id, want.broken, link.Broken(), link.CheckError)
+		}
+	}
+
+	if internalRequests.Load() != 0 {
+		t.Errorf("checker should not follow redirects to hosts it isn't allowed to request")
+	}
+
+	router, err := app.newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", apiVersionPath+"/reports/broken", nil))
+
+	var report struct {
+		Links []models.Link `json:"links"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil {
+		t.Fatalf("could not decode report: %v", err)
+	}
+	if len(report.Links) != 2 || report.Links[0].ID != "down" || report.Links[1].ID != "moved" {
+		t.Errorf("report should list the broken links; got %+v", report.Links)
+	}
+}
+
+func TestLinkCheckerFailover(t *testing.T) {
+	healthy := atomic.Bool{}
+	tool := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer tool.Close()
+
+	status := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer status.Close()
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	down.Close()
+
+	app := newTestApp(t)
+	link := models.CreateLinkRequest{ID: "tool", URL: tool.URL, Fallbacks: []string{down.URL, status.URL}}.ToLink()
+	if err := app.storage.CreateLink(context.Background(), link); err != nil {
+		t.Fatalf("could not create link: %v", err)
+	}
+
+	router, err := app.newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	checker := newLinkChecker(app.storage, &config.CheckerConfig{Concurrency: 1, RateLimit: 100, Timeout: time.Second})
+
+	tests := []struct {
+		name     string
+		healthy  bool
+		want     string
+		fallback bool
+	}{
+		{"up", true, tool.URL, false},
+		{"down", false, status.URL, true},
+		{"back up", true, tool.URL, false},
+	}
+
+	for _, tc := range tests {
+		healthy.Store(tc.healthy)
+		if err := checker.checkFallbacks(context.Background()); err != nil {
+			t.Fatalf("%s: could not check links: %v", tc.name, err)
+		}
+
+		link, err := app.storage.GetLink(context.Background(), "tool")
+		if err != nil {
+			t.Fatalf("%s: could not retrieve link: %v", tc.name, err)
+		}
+		if link.Failover == nil || link.Failover.URL != tc.want || (link.Failover.Fallback != nil) != tc.fallback {
+			t.Errorf("%s: unexpected failover; want %s; got %+v", tc.name, tc.want, link.Failover)
+		}
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", "/tool", nil))
+		if location := recorder.Header().Get("Location"); location != tc.want {
+			t.Errorf("%s: unexpected redirect; want %s; got %s", tc.name, tc.want, location)
+		}
+	}
+}
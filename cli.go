@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/clintjedwards/goto/client"
+	"github.com/clintjedwards/goto/config"
+	"github.com/clintjedwards/goto/models"
+)
+
+// linkCommand is a subcommand that manages links on a running server through the api
+type linkCommand struct {
+	name        string
+	args        []string // names of the positional arguments, shown in usage
+	description string
+	run         func(ctx context.Context, cmd *linkCommandEnv, args []string) error
+}
+
+// linkCommandEnv is what a linkCommand runs with
+type linkCommandEnv struct {
+	client *client.Client
+	// server is the address of the server, for building short links
+	server string
+	output string
+	quiet  bool
+	stdout io.Writer
+}
+
+var linkCommands = []linkCommand{
+	{
+		name:        "create",
+		args:        []string{"id", "url"},
+		description: "create a link; use {} in the url for a formatted link",
+		run:         runCreate,
+	},
+	{
+		name:        "ls",
+		description: "list all links",
+		run:         runList,
+	},
+	{
+		name:        "get",
+		args:        []string{"id"},
+		description: "show a link",
+		run:         runGet,
+	},
+	{
+		name:        "rm",
+		args:        []string{"id"},
+		description: "delete a link",
+		run:         runRemove,
+	},
+	{
+		name:        "open",
+		args:        []string{"id"},
+		description: "open a link in the browser",
+		run:         runOpen,
+	},
+}
+
+// findLinkCommand returns the link command with the given name, if there is one
+func findLinkCommand(name string) (linkCommand, bool) {
+	for _, command := range linkCommands {
+		if command.name == name {
+			return command, true
+		}
+	}
+
+	return linkCommand{}, false
+}
+
+// runLinkCommand parses flags shared by all link commands and runs command against the server
+func runLinkCommand(config *config.Config, command linkCommand, args []string, stdout io.Writer) error {
+	defaultServer := os.Getenv("GOTO_SERVER")
+	if defaultServer == "" {
+		defaultServer = "http://" + config.Host + config.APIPrefix
+	}
+
+	flags := flag.NewFlagSet(command.name, flag.ExitOnError)
+	server := flags.String("server", defaultServer, "address of the goto server, including any api prefix")
+	token := flags.String("token", firstOrEmpty(config.AuthTokens), "auth token to present to the server")
+	output := flags.String("output", "table", "output format; table or json")
+	quiet := flags.Bool("quiet", false, "only print link ids")
+	timeout := flags.Duration("timeout", 30*time.Second, "how long to wait for the server")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: goto %s [flags] %s\n\n%s\n\n", command.name,
+			argsUsage(command.args), command.description)
+		flags.PrintDefaults()
+	}
+	positional := parseInterspersed(flags, args)
+
+	if *output != "table" && *output != "json" {
+		flags.Usage()
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	if len(positional) != len(command.args) {
+		flags.Usage()
+		return fmt.Errorf("expected %d arguments; got %d", len(command.args), len(positional))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	return command.run(ctx, &linkCommandEnv{
+		client: client.New(*server, client.WithToken(*token)),
+		server: strings.TrimSuffix(*server, config.APIPrefix),
+		output: *output,
+		quiet:  *quiet,
+		stdout: stdout,
+	}, positional)
+}
+
+// parseInterspersed parses flags that appear before, between or after positional arguments
+// and returns the positional arguments. The flag package otherwise stops at the first one.
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	positional := []string{}
+
+	for {
+		_ = flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...)
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func argsUsage(args []string) string {
+	usage := []string{}
+	for _, arg := range args {
+		usage = append(usage, "<"+arg+">")
+	}
+	return strings.Join(usage, " ")
+}
+
+func runCreate(ctx context.Context, cmd *linkCommandEnv, args []string) error {
+	link, err := cmd.client.CreateLink(ctx, models.CreateLinkRequest{ID: args[0], URL: args[1]})
+	if err != nil {
+		return err
+	}
+
+	return cmd.printLink(link)
+}
+
+func runList(ctx context.Context, cmd *linkCommandEnv, _ []string) error {
+	links, err := cmd.client.ListLinks(ctx)
+	if err != nil {
+		return err
+	}
+
+	sorted := []models.Link{}
+	for _, link := range links {
+		sorted = append(sorted, link)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	return cmd.printLinks(sorted)
+}
+
+func runGet(ctx context.Context, cmd *linkCommandEnv, args []string) error {
+	link, err := cmd.client.GetLink(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	return cmd.printLink(link)
+}
+
+func runRemove(ctx context.Context, cmd *linkCommandEnv, args []string) error {
+	err := cmd.client.DeleteLink(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	if !cmd.quiet {
+		fmt.Fprintf(cmd.stdout, "deleted %s\n", args[0])
+	}
+	return nil
+}
+
+// runOpen opens the short link through the server rather than its url directly, so that
+// the visit is counted and formatted links are expanded the same way as in a browser.
+func runOpen(ctx context.Context, cmd *linkCommandEnv, args []string) error {
+	_, err := cmd.client.GetLink(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	shortLink := strings.TrimSuffix(cmd.server, "/") + "/" + args[0]
+	if !cmd.quiet {
+		fmt.Fprintf(cmd.stdout, "opening %s\n", shortLink)
+	}
+
+	return openBrowser(shortLink)
+}
+
+func openBrowser(url string) error {
+	var command *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		command = exec.Command("open", url)
+	case "windows":
+		command = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		command = exec.Command("xdg-open", url)
+	}
+
+	return command.Start()
+}
+
+// printLink writes a single link in the requested output format
+func (cmd *linkCommandEnv) printLink(link *models.Link) error {
+	if cmd.output == "json" && !cmd.quiet {
+		encoder := json.NewEncoder(cmd.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(link)
+	}
+
+	return cmd.printLinks([]models.Link{*link})
+}
+
+// printLinks writes links in the requested output format
+func (cmd *linkCommandEnv) printLinks(links []models.Link) error {
+	if cmd.quiet {
+		for _, link := range links {
+			fmt.Fprintln(cmd.stdout, link.ID)
+		}
+		return nil
+	}
+
+	if cmd.output == "json" {
+		encoder := json.NewEncoder(cmd.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(links)
+	}
+
+	table := tabwriter.NewWriter(cmd.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tURL\tKIND\tHITS\tCREATED\tLAST USED")
+	for _, link := range links {
+		lastUsed := "never"
+		if link.LastAccessed != 0 {
+			lastUsed = time.Unix(link.LastAccessed, 0).Format(time.DateTime)
+		}
+		if link.Archived != 0 {
+			lastUsed += " (archived)"
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%s\t%s\n", link.ID, link.URL, link.Kind, link.Hits,
+			time.Unix(link.Created, 0).Format(time.DateTime), lastUsed)
+	}
+	return table.Flush()
+}
+
+// printUsage lists every subcommand
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: goto [command]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "With no command, goto starts the server.")
+	fmt.Fprintln(w)
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "  server\tstart the server")
+	for _, command := range linkCommands {
+		fmt.Fprintf(table, "  %s %s\t%s\n", command.name, argsUsage(command.args), command.description)
+	}
+	fmt.Fprintln(table, "  migrate\tcopy links between storage engines")
+	fmt.Fprintln(table, "  backup\tdownload a snapshot of the database")
+	fmt.Fprintln(table, "  restore\treplace the database with a snapshot")
+	fmt.Fprintln(table, "  completion <shell>\tprint a completion script for bash, zsh or fish")
+	_ = table.Flush()
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run goto <command> -h for a command's flags.")
+}
+
+// commandNames returns the name of every subcommand, for completion
+func commandNames() []string {
+	names := []string{"server"}
+	for _, command := range linkCommands {
+		names = append(names, command.name)
+	}
+	return append(names, "migrate", "backup", "restore", "completion", "help")
+}
+
+// runCompletion prints a shell completion script. Link ids are completed by asking the
+// server for them with goto ls --quiet.
+func runCompletion(args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: goto completion <bash|zsh|fish>")
+	}
+
+	commands := strings.Join(commandNames(), " ")
+
+	switch args[0] {
+	case "bash":
+		fmt.Fprintf(stdout, bashCompletion, commands)
+	case "zsh":
+		fmt.Fprintf(stdout, "autoload -U +X bashcompinit && bashcompinit\n"+bashCompletion, commands)
+	case "fish":
+		fmt.Fprintf(stdout, fishCompletion, commands, commands)
+	default:
+		return fmt.Errorf("unsupported shell %q; expected bash, zsh or fish", args[0])
+	}
+
+	return nil
+}
+
+const bashCompletion = `_goto() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    if [ "$COMP_CWORD" -eq 1 ]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+        return
+    fi
+    case "${COMP_WORDS[1]}" in
+        get|rm|open)
+            if [ "$COMP_CWORD" -eq 2 ]; then
+                COMPREPLY=($(compgen -W "$(goto ls --quiet 2>/dev/null)" -- "$cur"))
+            fi
+            ;;
+        completion)
+            COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+            ;;
+    esac
+}
+complete -F _goto goto
+`
+
+const fishCompletion = `complete -c goto -f
+complete -c goto -n "not __fish_seen_subcommand_from %s" -a "%s"
+complete -c goto -n "__fish_seen_subcommand_from get rm open" -a "(goto ls --quiet 2>/dev/null)"
+complete -c goto -n "__fish_seen_subcommand_from completion" -a "bash zsh fish"
+`
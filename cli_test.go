@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/clintjedwards/goto/config"
+	"github.com/clintjedwards/goto/models"
+)
+
+func TestLinkCommands(t *testing.T) {
+	server := newTestServer(t, newTestApp(t), "")
+	cfg := &config.Config{}
+
+	run := func(name string, args ...string) string {
+		t.Helper()
+
+		command, ok := findLinkCommand(name)
+		if !ok {
+			t.Fatalf("no such command %q", name)
+		}
+
+		stdout := &bytes.Buffer{}
+		err := runLinkCommand(cfg, command, append([]string{"--server", server.URL}, args...), stdout)
+		if err != nil {
+			t.Fatalf("goto %s failed: %v", name, err)
+		}
+		return stdout.String()
+	}
+
+	run("create", "github", "https://github.com/{}", "--output", "json")
+	run("create", "docs", "https://go.dev/doc")
+
+	table := run("ls")
+	lines := strings.Split(strings.TrimSpace(table), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "ID") || !strings.HasPrefix(lines[1], "docs") {
+		t.Errorf("unexpected table output:\n%s", table)
+	}
+
+	if ids := run("ls", "--quiet"); ids != "docs\ngithub\n" {
+		t.Errorf("unexpected quiet output; got %q", ids)
+	}
+
+	var link models.Link
+	err := json.Unmarshal([]byte(run("get", "--output", "json", "github")), &link)
+	if err != nil || link.Kind != models.Formatted {
+		t.Errorf("unexpected json output; got %+v, err %v", link, err)
+	}
+
+	run("rm", "github")
+	if ids := run("ls", "--quiet"); ids != "docs\n" {
+		t.Errorf("removed link should no longer be listed; got %q", ids)
+	}
+}
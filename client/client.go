@@ -0,0 +1,257 @@
+// Package client calls the goto api.
+//
+// Errors returned by the server are returned as *errors.APIError, and can be checked against
+// errors.ErrNotFound and errors.ErrExists the same way as errors from a storage engine:
+//
+//	link, err := c.GetLink(ctx, "github")
+//	if errors.Is(err, utilErrors.ErrNotFound) {
+//		...
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+)
+
+// apiVersionPath is the version of the api this client speaks
+const apiVersionPath = "/api/v1"
+
+// Client calls the goto api. It is safe for concurrent use.
+// Requests are only bounded by their context, so callers should set deadlines as needed.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+	retries    int
+	retryWait  time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithToken sets the auth token presented to servers that require one for changes
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient sets the http client requests are made with
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how many times a failed request is retried and how long to wait before the
+// first retry; the wait doubles for each one after. Only requests that are safe to repeat are
+// retried, and only when the server could not be reached or was unavailable.
+func WithRetries(retries int, wait time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.retryWait = wait
+	}
+}
+
+// New creates a client for the goto server at address, including the api prefix if the server
+// is configured with one. ex. http://go.example.com or http://go.example.com/_
+func New(address string, options ...Option) *Client {
+	client := &Client{
+		baseURL:    strings.TrimSuffix(address, "/") + apiVersionPath,
+		httpClient: &http.Client{},
+		retries:    2,
+		retryWait:  100 * time.Millisecond,
+	}
+
+	for _, option := range options {
+		option(client)
+	}
+
+	return client
+}
+
+// ListLinks returns all links keyed by their ID
+func (c *Client) ListLinks(ctx context.Context) (map[string]models.Link, error) {
+	links := map[string]models.Link{}
+	err := c.do(ctx, http.MethodGet, "/links", nil, &links)
+	if err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// GetLink returns the link with the given ID
+func (c *Client) GetLink(ctx context.Context, id string) (*models.Link, error) {
+	link := &models.Link{}
+	err := c.do(ctx, http.MethodGet, "/links/"+url.PathEscape(id), nil, link)
+	if err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// CreateLink creates a new link and returns it as stored
+func (c *Client) CreateLink(ctx context.Context, request models.CreateLinkRequest) (*models.Link, error) {
+	link := &models.Link{}
+	err := c.do(ctx, http.MethodPost, "/links", request, link)
+	if err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// DeleteLink removes the link with the given ID
+func (c *Client) DeleteLink(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/links/"+url.PathEscape(id), nil, nil)
+}
+
+// Status returns nil if the server is ready to serve links
+func (c *Client) Status(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/status", nil, nil)
+}
+
+// VersionInfo describes the build of a running server
+type VersionInfo struct {
+	Version       string `json:"version"`
+	Commit        string `json:"commit"`
+	StorageEngine string `json:"storage_engine"`
+}
+
+// Version returns the build of the running server
+func (c *Client) Version(ctx context.Context) (*VersionInfo, error) {
+	info := &VersionInfo{}
+	err := c.do(ctx, http.MethodGet, "/version", nil, info)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// Backup writes a snapshot of the server's database to w and returns the number of bytes written.
+// Backups are not retried since part of the snapshot may already have been written.
+func (c *Client) Backup(ctx context.Context, w io.Writer) (int64, error) {
+	response, err := c.send(ctx, http.MethodGet, "/backup", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return 0, decodeError(response)
+	}
+
+	return io.Copy(w, response.Body)
+}
+
+// do makes a request to the api, retrying if appropriate, and decodes the response into result
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	retryable := method == http.MethodGet || method == http.MethodDelete
+	wait := c.retryWait
+
+	for attempt := 0; ; attempt++ {
+		response, err := c.send(ctx, method, path, payload)
+		if err == nil && (!retryable || !unavailable(response.StatusCode)) {
+			return decodeResponse(response, result)
+		}
+
+		if err == nil {
+			apiErr := decodeError(response)
+			response.Body.Close()
+			err = apiErr
+		}
+		if !retryable || attempt >= c.retries || ctx.Err() != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// send makes a single request to the api
+func (c *Client) send(ctx context.Context, method, path string, payload []byte) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	return c.httpClient.Do(request)
+}
+
+// decodeResponse reads a successful response into result, or the api error from an unsuccessful one
+func decodeResponse(response *http.Response, result interface{}) error {
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		return decodeError(response)
+	}
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// unavailable reports whether a status means the request might succeed if tried again
+func unavailable(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// decodeError reads the api error from an unsuccessful response. Responses that didn't come
+// from the api, such as those from a proxy, are reported with their status.
+func decodeError(response *http.Response) error {
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("server returned %s", response.Status)
+	}
+
+	var envelope struct {
+		Error *utilErrors.APIError `json:"error"`
+	}
+	err = json.Unmarshal(body, &envelope)
+	if err != nil || envelope.Error == nil || envelope.Error.Code == "" {
+		return fmt.Errorf("server returned %s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+
+	return envelope.Error
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+)
+
+func TestRetries(t *testing.T) {
+	tests := map[string]struct {
+		method   string
+		failures int32
+		attempts int32
+		wantErr  bool
+	}{
+		"recovers": {
+			method:   http.MethodGet,
+			failures: 2,
+			attempts: 3,
+			wantErr:  false,
+		},
+		"gives up": {
+			method:   http.MethodGet,
+			failures: 5,
+			attempts: 3,
+			wantErr:  true,
+		},
+		"create is not retried": {
+			method:   http.MethodPost,
+			failures: 1,
+			attempts: 1,
+			wantErr:  true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			attempts := atomic.Int32{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if attempts.Add(1) <= tc.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					_, _ = w.Write([]byte(`{"error": {"code": "storage_unavailable", "message": "storage engine unavailable"}}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{"id": "github", "url": "https://github.com"}`))
+			}))
+			defer server.Close()
+
+			client := New(server.URL, WithRetries(2, time.Millisecond))
+
+			var err error
+			if tc.method == http.MethodPost {
+				_, err = client.CreateLink(context.Background(), models.CreateLinkRequest{ID: "github", URL: "https://github.com"})
+			} else {
+				_, err = client.GetLink(context.Background(), "github")
+			}
+
+			if (err != nil) != tc.wantErr {
+				t.Errorf("unexpected error; want error %v; got %v", tc.wantErr, err)
+			}
+			if attempts.Load() != tc.attempts {
+				t.Errorf("unexpected number of attempts; want %d; got %d", tc.attempts, attempts.Load())
+			}
+		})
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/v1/links/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": {"code": "link_not_found", "message": "link not found", "request_id": "abc"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte("upstream unavailable"))
+	}))
+	defer server.Close()
+
+	client := New(server.URL, WithRetries(0, 0))
+
+	_, err := client.GetLink(context.Background(), "missing")
+	if !errors.Is(err, utilErrors.ErrNotFound) {
+		t.Errorf("missing link should match ErrNotFound; got %v", err)
+	}
+
+	var apiErr *utilErrors.APIError
+	if !errors.As(err, &apiErr) || apiErr.RequestID != "abc" {
+		t.Errorf("api error should be returned with its request id; got %v", err)
+	}
+
+	_, err = client.GetLink(context.Background(), "other")
+	if err == nil || errors.As(err, &apiErr) {
+		t.Errorf("response from outside the api should not be decoded as an api error; got %v", err)
+	}
+}
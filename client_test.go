@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/clintjedwards/goto/client"
+	"github.com/clintjedwards/goto/config"
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+	"github.com/clintjedwards/goto/storage/bolt"
+)
+
+// newTestServer runs the real router for app under httptest
+func newTestServer(t *testing.T, app *app, apiPrefix string) *httptest.Server {
+	t.Helper()
+
+	router, err := app.newRouter(apiPrefix)
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, newTestApp(t), "/_")
+	c := client.New(server.URL + "/_")
+
+	created, err := c.CreateLink(ctx, models.CreateLinkRequest{ID: "github", URL: "https://github.com"})
+	if err != nil {
+		t.Fatalf("could not create link: %v", err)
+	}
+	if created.ID != "github" || created.Kind != models.Standard {
+		t.Errorf("unexpected created link; got %+v", created)
+	}
+
+	_, err = c.CreateLink(ctx, models.CreateLinkRequest{ID: "github", URL: "https://github.com"})
+	if !errors.Is(err, utilErrors.ErrExists) {
+		t.Errorf("duplicate link should match ErrExists; got %v", err)
+	}
+
+	_, err = c.CreateLink(ctx, models.CreateLinkRequest{ID: "_", URL: "https://github.com"})
+	var apiErr *utilErrors.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != utilErrors.CodeReservedID {
+		t.Errorf("reserved id should be rejected with its code; got %v", err)
+	}
+
+	link, err := c.GetLink(ctx, "github")
+	if err != nil || link.URL != "https://github.com" {
+		t.Errorf("could not get link; got %+v, err %v", link, err)
+	}
+
+	links, err := c.ListLinks(ctx)
+	if err != nil || len(links) != 1 {
+		t.Errorf("could not list links; got %v, err %v", links, err)
+	}
+
+	err = c.DeleteLink(ctx, "github")
+	if err != nil {
+		t.Errorf("could not delete link: %v", err)
+	}
+
+	_, err = c.GetLink(ctx, "github")
+	if !errors.Is(err, utilErrors.ErrNotFound) {
+		t.Errorf("deleted link should match ErrNotFound; got %v", err)
+	}
+
+	err = c.Status(ctx)
+	if err != nil {
+		t.Errorf("server should be ready: %v", err)
+	}
+
+	info, err := c.Version(ctx)
+	if err != nil || info.StorageEngine != "bolt" {
+		t.Errorf("could not get version; got %+v, err %v", info, err)
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	ctx := context.Background()
+	app := newTestApp(t)
+	app.config.Store(&config.Config{
+		MaxIDLength: 50,
+		AuthTokens:  []string{"s3cret"},
+		Database:    &config.DatabaseConfig{Engine: "bolt"},
+		Visits:      &config.VisitsConfig{},
+		Policy:      &config.PolicyConfig{},
+	})
+	server := newTestServer(t, app, "")
+
+	_, err := client.New(server.URL).CreateLink(ctx, models.CreateLinkRequest{ID: "github", URL: "https://github.com"})
+	var apiErr *utilErrors.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != utilErrors.CodeUnauthorized {
+		t.Errorf("create without a token should be unauthorized; got %v", err)
+	}
+
+	c := client.New(server.URL, client.WithToken("s3cret"))
+	_, err = c.CreateLink(ctx, models.CreateLinkRequest{ID: "github", URL: "https://github.com"})
+	if err != nil {
+		t.Fatalf("could not create link with token: %v", err)
+	}
+
+	snapshot := &bytes.Buffer{}
+	_, err = c.Backup(ctx, snapshot)
+	if err != nil {
+		t.Fatalf("could not take backup: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	err = os.WriteFile(path, snapshot.Bytes(), 0600)
+	if err != nil {
+		t.Fatalf("could not write backup: %v", err)
+	}
+	count, err := bolt.ValidateSnapshot(path)
+	if err != nil || count != 1 {
+		t.Errorf("backup should hold the created link; got %d links, err %v", count, err)
+	}
+}
@@ -1,50 +1,473 @@
 package config
 
-import "github.com/kelseyhightower/envconfig"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"gopkg.in/yaml.v3"
+)
 
 // All env vars are prefixed with "goto"
 // example: GOTO_DATABASE_HOST_REDIS
+//
+// Settings in sections are named after the section and the field, ex. GOTO_TLS_CERT. Their fields
+// are named with split_words rather than an envconfig tag, since envconfig also reads a tag on
+// its own, ex. CERT, which other programs' settings are easily mistaken for.
+//
+// Settings may also be provided through a yaml file, in which case any
+// environment variable that is set takes precedence over the file.
+
+// envPrefix is prepended to all environment variable names
+const envPrefix = "goto"
+
+// idCharacters are the characters short names may be made of
+const idCharacters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-"
 
 // Config refers to general application configuration
 type Config struct {
-	Debug       bool   `envconfig:"debug" default:"false"`
-	LogLevel    string `envconfig:"loglevel" default:"info"`
-	Host        string `envconfig:"host" default:"localhost:8080"`
-	MaxIDLength int    `envconfig:"max_id_length" default:"50"` // The total amount of characters that a short name can be
-	Database    *DatabaseConfig
+	Debug           bool            `envconfig:"debug" default:"false" yaml:"debug"`
+	LogLevel        string          `envconfig:"loglevel" default:"info" yaml:"loglevel"`
+	Host            string          `envconfig:"host" default:"localhost:8080" yaml:"host"`
+	UnixSocket      string          `envconfig:"unix_socket" yaml:"unix_socket"`                         // Path of an additional unix socket to serve on, for local reverse proxies
+	MaxIDLength     int             `envconfig:"max_id_length" default:"50" yaml:"max_id_length"`        // The total amount of characters that a short name can be
+	ReservedIDs     []string        `envconfig:"reserved_ids" yaml:"reserved_ids"`                       // Short names that cannot be used in addition to the app's own routes
+	APIPrefix       string          `envconfig:"api_prefix" yaml:"api_prefix"`                           // Path the management api is served under, ex. /_; the root when empty
+	AuthTokens      []string        `envconfig:"auth_tokens" yaml:"auth_tokens"`                         // Bearer tokens allowed to change links; anyone may when empty
+	ShutdownTimeout time.Duration   `envconfig:"shutdown_timeout" default:"15s" yaml:"shutdown_timeout"` // How long to wait for in-flight work when stopping
+	Aliases         []string        `envconfig:"aliases" yaml:"aliases"`                                 // Other hostnames the server is reached at, ex. go,go.corp.example; any port matches unless one is given
+	MaxHops         int             `envconfig:"max_hops" default:"5" yaml:"max_hops"`                   // Most links on this server a link may redirect through to reach its destination
+	Timezone        string          `envconfig:"timezone" default:"UTC" yaml:"timezone"`                 // IANA timezone link schedules are evaluated in unless they name their own
+	GroupsHeader    string          `envconfig:"groups_header" yaml:"groups_header"`                     // Header an authenticating proxy lists the visitor's groups in, ex. X-Forwarded-Groups; group conditions never match when empty
+	Database        *DatabaseConfig `yaml:"database"`
+	Backup          *BackupConfig   `envconfig:"backup" yaml:"backup"`
+	Tracing         *TracingConfig  `envconfig:"tracing" yaml:"tracing"`
+	TLS             *TLSConfig      `envconfig:"tls" yaml:"tls"`
+	Visits          *VisitsConfig   `envconfig:"visits" yaml:"visits"`
+	Stale           *StaleConfig    `envconfig:"stale" yaml:"stale"`
+	Checker         *CheckerConfig  `envconfig:"checker" yaml:"checker"`
+	Policy          *PolicyConfig   `envconfig:"policy" yaml:"policy"`
+	IDs             *IDsConfig      `envconfig:"ids" yaml:"ids"`
 }
 
 // BoltConfig represents a on-disk key/value store
 // https://github.com/boltdb/bolt
 type BoltConfig struct {
 	// file path for database file
-	Path string `envconfig:"database_path_bolt" default:"/tmp/go.db"`
+	Path string `envconfig:"database_path_bolt" default:"/tmp/go.db" yaml:"path"`
 }
 
 // RedisConfig represents a key/value store
 // https://redis.io
 type RedisConfig struct {
-	Host     string `envconfig:"database_host_redis" default:"localhost:6379"`
-	Password string `envconfig:"database_password_redis"`
-	DB       int    `envconfig:"database_db_redis" default:"0"` // redis database number 0-15
+	Host     string `envconfig:"database_host_redis" default:"localhost:6379" yaml:"host"`
+	Password string `envconfig:"database_password_redis" yaml:"password"`
+	DB       int    `envconfig:"database_db_redis" default:"0" yaml:"db"` // redis database number 0-15
 }
 
 // DatabaseConfig defines config settings for comet database
 type DatabaseConfig struct {
 	// The database engine used by the backend
 	// possible values are: bolt, redis
-	Engine string `envconfig:"database_engine" default:"bolt"`
-	Bolt   *BoltConfig
-	Redis  *RedisConfig
+	Engine string       `envconfig:"database_engine" default:"bolt" yaml:"engine"`
+	Bolt   *BoltConfig  `yaml:"bolt"`
+	Redis  *RedisConfig `yaml:"redis"`
+}
+
+// TLSConfig enables serving https alongside plain http
+// example: GOTO_TLS_CERT=/etc/goto/cert.pem GOTO_TLS_KEY=/etc/goto/key.pem
+type TLSConfig struct {
+	// https is served only when both a certificate and key are provided
+	Cert string `split_words:"true" yaml:"cert"`
+	Key  string `split_words:"true" yaml:"key"`
+	// address to serve https on
+	Host string `split_words:"true" default:"localhost:8443" yaml:"host"`
+	// send plain http requests for everything but short links to https
+	RedirectHTTP bool `split_words:"true" default:"false" yaml:"redirect_http"`
+}
+
+// Enabled reports whether a certificate has been configured
+func (c *TLSConfig) Enabled() bool {
+	return c.Cert != "" && c.Key != ""
+}
+
+// BackupConfig controls scheduled snapshots of the database
+// example: GOTO_BACKUP_DIR=/var/lib/goto/backups GOTO_BACKUP_INTERVAL=24h
+type BackupConfig struct {
+	// directory in which snapshots are written
+	Dir string `split_words:"true" default:"/var/lib/goto/backups" yaml:"dir"`
+	// time between snapshots; scheduled backups are disabled when zero
+	Interval time.Duration `split_words:"true" default:"0" yaml:"interval"`
+	// number of most recent snapshots to keep
+	Retain int `split_words:"true" default:"7" yaml:"retain"`
+}
+
+// TracingConfig controls where request traces are exported
+// example: GOTO_TRACING_EXPORTER=otlp GOTO_TRACING_ENDPOINT=localhost:4318
+type TracingConfig struct {
+	// where spans are sent
+	// possible values are: none, stdout, otlp
+	Exporter string `split_words:"true" default:"none" yaml:"exporter"`
+	// host:port of an otlp http collector
+	Endpoint string `split_words:"true" default:"localhost:4318" yaml:"endpoint"`
+	// send spans to the collector without tls
+	Insecure bool `split_words:"true" default:"false" yaml:"insecure"`
+	// fraction of new traces that are recorded; 0 through 1
+	SampleRatio float64 `split_words:"true" default:"1" yaml:"sample_ratio"`
+}
+
+// VisitsConfig controls what is recorded about where each redirect came from. Hit counts are
+// always kept; these settings only affect the per-link breakdown of visits.
+// example: GOTO_VISITS_NETWORKS=off GOTO_VISITS_REFERRERS=false
+type VisitsConfig struct {
+	// count the host of the page each visit came from; the rest of the referring url is dropped
+	Referrers bool `split_words:"true" default:"true" yaml:"referrers"`
+	// count the browser family each visit was made with, ex. Firefox
+	UserAgents bool `split_words:"true" default:"true" yaml:"user_agents"`
+	// count the network each visit came from; a /24 for ipv4 and /48 for ipv6, never the full address
+	// possible values are: off, hashed, full
+	Networks string `split_words:"true" default:"hashed" yaml:"networks"`
+	// secret mixed into hashed networks; a random one is used each run when empty, so hashes
+	// from before a restart won't match those after
+	NetworkSalt string `split_words:"true" yaml:"network_salt"`
+	// skip the breakdown for clients sending DNT: 1 or Sec-GPC: 1
+	HonorDoNotTrack bool `split_words:"true" default:"true" yaml:"honor_do_not_track"`
 }
 
-// FromEnv pulls configration from environment variables
-func FromEnv() (*Config, error) {
+// StaleConfig controls what happens to links that go unused
+// example: GOTO_STALE_AFTER=8760h GOTO_STALE_ACTION=archive
+type StaleConfig struct {
+	// how long a link can go without being followed before it is stale; the policy is disabled when zero
+	After time.Duration `split_words:"true" default:"0" yaml:"after"`
+	// what to do with stale links
+	// possible values are: notify, archive
+	Action string `split_words:"true" default:"notify" yaml:"action"`
+	// url sent a POST listing the links found stale by each check; they are only logged when empty
+	WebhookURL string `split_words:"true" yaml:"webhook_url"`
+	// time between checks for stale links
+	CheckInterval time.Duration `split_words:"true" default:"24h" yaml:"check_interval"`
+}
+
+// CheckerConfig controls the background checks that links' destinations still work
+// example: GOTO_CHECKER_INTERVAL=24h GOTO_CHECKER_ALLOWED_HOSTS=wiki.example.com,*.corp.example.com
+type CheckerConfig struct {
+	// time between checking every link; the checker is disabled when zero
+	Interval time.Duration `split_words:"true" default:"0" yaml:"interval"`
+	// number of destinations checked at the same time
+	Concurrency int `split_words:"true" default:"4" yaml:"concurrency"`
+	// most requests made per second across all checks
+	RateLimit float64 `split_words:"true" default:"2" yaml:"rate_limit"`
+	// how long a destination has to respond
+	Timeout time.Duration `split_words:"true" default:"10s" yaml:"timeout"`
+	// time between checking links with fallbacks, so they fail over soon after their url goes down;
+	// they are only checked with every other link when zero
+	FallbackInterval time.Duration `split_words:"true" default:"1m" yaml:"fallback_interval"`
+	// hosts whose destinations are checked; *.example.com matches any subdomain. Every host is
+	// checked when empty.
+	AllowedHosts []string `split_words:"true" yaml:"allowed_hosts"`
+}
+
+// PolicyConfig restricts where links may point. Domains match themselves and their subdomains,
+// and denied domains take precedence over allowed ones.
+// example: GOTO_POLICY_DENIED_DOMAINS=pastebin.com,paste.ee
+type PolicyConfig struct {
+	// schemes links may use
+	AllowedSchemes []string `split_words:"true" default:"http,https" yaml:"allowed_schemes"`
+	// domains links may point at; any domain that isn't denied is allowed when empty
+	AllowedDomains []string `split_words:"true" yaml:"allowed_domains"`
+	// domains links may never point at
+	DeniedDomains []string `split_words:"true" yaml:"denied_domains"`
+}
+
+// IDsConfig controls the short names generated for links created without one
+// example: GOTO_IDS_LENGTH=8 GOTO_IDS_BLOCKED_WORDS=acme,intern
+type IDsConfig struct {
+	// characters generated short names are made of; the default leaves out vowels so that no words
+	// can be spelled, along with characters easily mistaken for others such as 0, 1 and l
+	Alphabet string `split_words:"true" default:"23456789bcdfghjkmnpqrstvwxyz" yaml:"alphabet"`
+	// number of characters in generated short names
+	Length int `split_words:"true" default:"6" yaml:"length"`
+	// words generated short names must not contain, on top of a built in list of profanity
+	BlockedWords []string `split_words:"true" yaml:"blocked_words"`
+	// fetch a destination's page title to suggest short names from when one isn't given
+	FetchTitles bool `split_words:"true" default:"false" yaml:"fetch_titles"`
+}
+
+// Load reads configuration from the yaml file at path, if one is given, and then applies
+// any settings provided through environment variables on top. Settings found in neither
+// take their default value. The resulting configuration is validated before being returned.
+func Load(path string) (*Config, error) {
 	var config Config
-	err := envconfig.Process("goto", &config)
+	err := envconfig.Process(envPrefix, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	if path != "" {
+		// Environment variables were already applied along with the defaults above, so we
+		// keep a separate copy of them to reapply once the file has been read over the top.
+		var envConfig Config
+		err = envconfig.Process(envPrefix, &envConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		file, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read config file: %w", err)
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(file))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&config)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("could not parse config file %s: %w", path, err)
+		}
+
+		applyEnvOverrides(strings.ToUpper(envPrefix), reflect.ValueOf(&config).Elem(),
+			reflect.ValueOf(&envConfig).Elem())
+	}
+
+	err = config.Validate()
 	if err != nil {
 		return nil, err
 	}
 
 	return &config, nil
 }
+
+// applyEnvOverrides copies every field that was explicitly set through an environment variable
+// from env into config. Variable names are worked out the same way envconfig does. Only prefixed
+// names take precedence over the file; the unprefixed ones envconfig also reads for tagged fields,
+// ex. HOST, are too easily set for other programs to override it.
+func applyEnvOverrides(prefix string, config, env reflect.Value) {
+	for i := 0; i < config.NumField(); i++ {
+		field := config.Type().Field(i)
+
+		key := field.Name
+		if tag := field.Tag.Get("envconfig"); tag != "" {
+			key = tag
+		} else if field.Tag.Get("split_words") == "true" {
+			key = splitWords(field.Name)
+		}
+		key = strings.ToUpper(prefix + "_" + key)
+
+		configField := config.Field(i)
+		envField := env.Field(i)
+
+		if configField.Kind() == reflect.Ptr && configField.Type().Elem().Kind() == reflect.Struct {
+			// Sections left empty in the file, ex. "checker:", take their defaults along with
+			// any environment variables
+			if configField.IsNil() {
+				configField.Set(envField)
+				continue
+			}
+			applyEnvOverrides(key, configField.Elem(), envField.Elem())
+			continue
+		}
+
+		if _, set := os.LookupEnv(key); set {
+			configField.Set(envField)
+		}
+	}
+}
+
+var (
+	wordsRegexp   = regexp.MustCompile("([^A-Z]+|[A-Z]+[^A-Z]+|[A-Z]+)")
+	acronymRegexp = regexp.MustCompile("([A-Z]+)([A-Z][^A-Z]+)")
+)
+
+// splitWords names a field the way envconfig's split_words does, ex. RedirectHTTP is REDIRECT_HTTP
+func splitWords(name string) string {
+	words := []string{}
+	for _, word := range wordsRegexp.FindAllString(name, -1) {
+		if parts := acronymRegexp.FindStringSubmatch(word); len(parts) == 3 {
+			words = append(words, parts[1], parts[2])
+			continue
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, "_")
+}
+
+// Validate checks that all settings are usable, returning every problem found at once
+func (c *Config) Validate() error {
+	// Every other check relies on the sections being there, which Load makes sure of
+	if missing := missingSections(reflect.ValueOf(c).Elem()); len(missing) > 0 {
+		return fmt.Errorf("invalid configuration: missing %s settings", strings.Join(missing, ", "))
+	}
+
+	errs := []error{}
+
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error", "fatal", "panic":
+	default:
+		errs = append(errs, fmt.Errorf("loglevel %q must be one of debug, info, warn, error, fatal, panic", c.LogLevel))
+	}
+
+	if c.MaxIDLength < 1 {
+		errs = append(errs, fmt.Errorf("max_id_length must be at least 1; got %d", c.MaxIDLength))
+	}
+
+	if c.APIPrefix != "" && (!strings.HasPrefix(c.APIPrefix, "/") || strings.HasSuffix(c.APIPrefix, "/")) {
+		errs = append(errs, fmt.Errorf("api_prefix %q must start with a slash and not end with one", c.APIPrefix))
+	}
+
+	if c.MaxHops < 0 {
+		errs = append(errs, fmt.Errorf("max_hops must not be negative; got %d", c.MaxHops))
+	}
+
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		errs = append(errs, fmt.Errorf("timezone %q is not a known IANA timezone", c.Timezone))
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown_timeout must be positive; got %s", c.ShutdownTimeout))
+	}
+
+	for _, token := range c.AuthTokens {
+		if strings.TrimSpace(token) == "" {
+			errs = append(errs, errors.New("auth_tokens must not contain empty tokens"))
+			break
+		}
+	}
+
+	switch c.Database.Engine {
+	case "bolt":
+		if c.Database.Bolt.Path == "" {
+			errs = append(errs, errors.New("database bolt path must be set when using the bolt engine"))
+		}
+	case "redis":
+		if c.Database.Redis.Host == "" {
+			errs = append(errs, errors.New("database redis host must be set when using the redis engine"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("database engine %q must be one of bolt, redis", c.Database.Engine))
+	}
+
+	if c.Backup.Interval < 0 {
+		errs = append(errs, fmt.Errorf("backup interval must not be negative; got %s", c.Backup.Interval))
+	}
+	if c.Backup.Interval > 0 && c.Backup.Dir == "" {
+		errs = append(errs, errors.New("backup dir must be set when backups are scheduled"))
+	}
+	if c.Backup.Retain < 1 {
+		errs = append(errs, fmt.Errorf("backup retain must be at least 1; got %d", c.Backup.Retain))
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("tracing exporter %q must be one of none, stdout, otlp", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing sample_ratio must be between 0 and 1; got %v", c.Tracing.SampleRatio))
+	}
+
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		errs = append(errs, errors.New("tls cert and key must be set together"))
+	}
+
+	if c.Stale.After < 0 {
+		errs = append(errs, fmt.Errorf("stale after must not be negative; got %s", c.Stale.After))
+	}
+	if c.Stale.After > 0 && c.Stale.CheckInterval <= 0 {
+		errs = append(errs, fmt.Errorf("stale check_interval must be positive; got %s", c.Stale.CheckInterval))
+	}
+	switch c.Stale.Action {
+	case "notify", "archive":
+	default:
+		errs = append(errs, fmt.Errorf("stale action %q must be one of notify, archive", c.Stale.Action))
+	}
+
+	if c.Checker.Interval < 0 {
+		errs = append(errs, fmt.Errorf("checker interval must not be negative; got %s", c.Checker.Interval))
+	}
+	if c.Checker.Concurrency < 1 {
+		errs = append(errs, fmt.Errorf("checker concurrency must be at least 1; got %d", c.Checker.Concurrency))
+	}
+	if c.Checker.RateLimit <= 0 {
+		errs = append(errs, fmt.Errorf("checker rate_limit must be positive; got %v", c.Checker.RateLimit))
+	}
+	if c.Checker.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("checker timeout must be positive; got %s", c.Checker.Timeout))
+	}
+	if c.Checker.FallbackInterval < 0 {
+		errs = append(errs, fmt.Errorf("checker fallback_interval must not be negative; got %s", c.Checker.FallbackInterval))
+	}
+
+	if len(c.Policy.AllowedSchemes) == 0 {
+		errs = append(errs, errors.New("policy allowed_schemes must list at least one scheme"))
+	}
+
+	switch c.Visits.Networks {
+	case "off", "hashed", "full":
+	default:
+		errs = append(errs, fmt.Errorf("visits networks %q must be one of off, hashed, full", c.Visits.Networks))
+	}
+
+	if c.IDs.Length < 1 || c.IDs.Length > c.MaxIDLength {
+		errs = append(errs, fmt.Errorf("ids length must be between 1 and max_id_length; got %d", c.IDs.Length))
+	}
+	if strings.Trim(c.IDs.Alphabet, idCharacters) != "" {
+		errs = append(errs, fmt.Errorf("ids alphabet %q may only contain letters, digits, dashes and underscores", c.IDs.Alphabet))
+	}
+	if c.IDs.Alphabet == "" || strings.Trim(c.IDs.Alphabet, c.IDs.Alphabet[:1]) == "" {
+		errs = append(errs, errors.New("ids alphabet must have at least two different characters"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// missingSections returns the yaml names of the nil sections in config, including nested ones
+func missingSections(config reflect.Value) []string {
+	missing := []string{}
+	for i := 0; i < config.NumField(); i++ {
+		field := config.Field(i)
+		if field.Kind() != reflect.Ptr || field.Type().Elem().Kind() != reflect.Struct {
+			continue
+		}
+
+		name, _, _ := strings.Cut(config.Type().Field(i).Tag.Get("yaml"), ",")
+		if field.IsNil() {
+			missing = append(missing, name)
+			continue
+		}
+		for _, nested := range missingSections(field.Elem()) {
+			missing = append(missing, name+"."+nested)
+		}
+	}
+	return missing
+}
+
+// WithReloaded returns a copy of the configuration with the settings that are safe to change
+// while running taken from updated. All other settings only take effect on restart.
+func (c *Config) WithReloaded(updated *Config) *Config {
+	reloaded := *c
+
+	reloaded.LogLevel = updated.LogLevel
+	reloaded.MaxIDLength = updated.MaxIDLength
+	reloaded.ReservedIDs = updated.ReservedIDs
+	reloaded.AuthTokens = updated.AuthTokens
+	reloaded.Aliases = updated.Aliases
+	reloaded.MaxHops = updated.MaxHops
+	reloaded.Timezone = updated.Timezone
+	reloaded.GroupsHeader = updated.GroupsHeader
+	reloaded.Visits = updated.Visits
+	reloaded.Stale = updated.Stale
+	reloaded.Policy = updated.Policy
+	reloaded.IDs = updated.IDs
+
+	return &reloaded
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "goto.yml")
+	err := os.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatalf("could not write config file: %v", err)
+	}
+
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+host: 0.0.0.0:9000
+max_id_length: 20
+reserved_ids: [admin, login]
+database:
+  bolt:
+    path: /srv/goto.db
+backup:
+  retain: 3
+`)
+
+	t.Setenv("GOTO_MAX_ID_LENGTH", "30")
+	t.Setenv("GOTO_BACKUP_RETAIN", "5")
+	t.Setenv("DATABASE_PATH_BOLT", "/data/goto.db")
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+
+	if config.Host != "0.0.0.0:9000" {
+		t.Errorf("file setting should override default; got host %q", config.Host)
+	}
+	if config.MaxIDLength != 30 {
+		t.Errorf("env setting should override file; got max id length %d", config.MaxIDLength)
+	}
+	if config.Backup.Retain != 5 {
+		t.Errorf("prefixed env setting should override file; got retain %d", config.Backup.Retain)
+	}
+	if config.Database.Bolt.Path != "/srv/goto.db" {
+		t.Errorf("unprefixed env setting should not override file; got bolt path %q", config.Database.Bolt.Path)
+	}
+	if strings.Join(config.ReservedIDs, ",") != "admin,login" {
+		t.Errorf("unexpected reserved ids; got %v", config.ReservedIDs)
+	}
+	if config.ShutdownTimeout != 15*time.Second {
+		t.Errorf("unset setting should keep its default; got shutdown timeout %s", config.ShutdownTimeout)
+	}
+}
+
+func TestLoadIgnoresUnprefixedOverrides(t *testing.T) {
+	path := writeConfigFile(t, `
+host: 0.0.0.0:9000
+max_id_length: 20
+ids:
+  length: 8
+`)
+
+	t.Setenv("HOST", "0.0.0.0:1")
+	t.Setenv("MAX_ID_LENGTH", "10")
+	t.Setenv("LENGTH", "3")
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+
+	if config.Host != "0.0.0.0:9000" || config.MaxIDLength != 20 || config.IDs.Length != 8 {
+		t.Errorf("unprefixed env settings should not override file; got host %q, max id length %d and ids length %d",
+			config.Host, config.MaxIDLength, config.IDs.Length)
+	}
+}
+
+func TestLoadEmptySections(t *testing.T) {
+	t.Setenv("GOTO_CHECKER_CONCURRENCY", "8")
+
+	config, err := Load(writeConfigFile(t, "database: null\nchecker:\ntls:\n  cert: \"\"\n"))
+	if err != nil {
+		t.Fatalf("empty sections should take their defaults; got %v", err)
+	}
+
+	if config.Database.Engine != "bolt" || config.Database.Bolt == nil {
+		t.Errorf("empty database section should keep its defaults; got %+v", config.Database)
+	}
+	if config.Checker.Concurrency != 8 || config.Checker.Timeout != 10*time.Second {
+		t.Errorf("empty checker section should keep its defaults and env settings; got %+v", config.Checker)
+	}
+}
+
+func TestValidateMissingSections(t *testing.T) {
+	config, err := Load("")
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+
+	config.Checker = nil
+	config.Database.Redis = nil
+
+	err = config.Validate()
+	if err == nil || !strings.Contains(err.Error(), "checker") || !strings.Contains(err.Error(), "database.redis") {
+		t.Errorf("missing sections should be reported; got %v", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := map[string]struct {
+		file string
+		want []string
+	}{
+		"unknown setting": {
+			file: "max_id_lenght: 20\n",
+			want: []string{"max_id_lenght"},
+		},
+		"multiple invalid settings": {
+			file: "loglevel: verbose\ndatabase:\n  engine: mongo\ntls:\n  cert: /etc/goto/cert.pem\n",
+			want: []string{"loglevel", "database engine", "tls cert and key"},
+		},
+		"visits networks": {
+			file: "visits:\n  networks: exact\n",
+			want: []string{"visits networks"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load(writeConfigFile(t, tc.file))
+			if err == nil {
+				t.Fatal("expected config to be rejected")
+			}
+
+			for _, want := range tc.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error should mention %q; got %q", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadSectionEnvNames(t *testing.T) {
+	prefixed := map[string]string{
+		"GOTO_TLS_CERT":                  "/etc/goto/cert.pem",
+		"GOTO_TLS_KEY":                   "/etc/goto/key.pem",
+		"GOTO_TLS_HOST":                  "0.0.0.0:443",
+		"GOTO_TLS_REDIRECT_HTTP":         "true",
+		"GOTO_BACKUP_DIR":                "/srv/backups",
+		"GOTO_BACKUP_INTERVAL":           "12h",
+		"GOTO_TRACING_SAMPLE_RATIO":      "0.5",
+		"GOTO_VISITS_HONOR_DO_NOT_TRACK": "false",
+		"GOTO_STALE_WEBHOOK_URL":         "https://hooks.example.com",
+		"GOTO_CHECKER_RATE_LIMIT":        "5",
+		"GOTO_POLICY_DENIED_DOMAINS":     "pastebin.com",
+		"GOTO_IDS_LENGTH":                "8",
+	}
+	for key, value := range prefixed {
+		t.Setenv(key, value)
+	}
+
+	// Names other programs might set that envconfig would otherwise take for the sections' settings
+	for _, key := range []string{"CERT", "KEY", "HOST", "DIR", "RETAIN", "EXPORTER", "NETWORKS", "AFTER", "ACTION",
+		"TIMEOUT", "CONCURRENCY", "LENGTH", "ALPHABET"} {
+		t.Setenv(key, "1")
+	}
+
+	config, err := Load("")
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+
+	if config.TLS.Cert != "/etc/goto/cert.pem" || config.TLS.Key != "/etc/goto/key.pem" ||
+		config.TLS.Host != "0.0.0.0:443" || !config.TLS.RedirectHTTP {
+		t.Errorf("tls settings should be read from their prefixed names; got %+v", config.TLS)
+	}
+	if config.Backup.Dir != "/srv/backups" || config.Backup.Interval != 12*time.Hour || config.Backup.Retain != 7 {
+		t.Errorf("backup settings should be read from their prefixed names only; got %+v", config.Backup)
+	}
+	if config.Tracing.SampleRatio != 0.5 || config.Tracing.Exporter != "none" {
+		t.Errorf("tracing settings should be read from their prefixed names only; got %+v", config.Tracing)
+	}
+	if config.Visits.HonorDoNotTrack || config.Visits.Networks != "hashed" {
+		t.Errorf("visits settings should be read from their prefixed names only; got %+v", config.Visits)
+	}
+	if config.Stale.WebhookURL != "https://hooks.example.com" || config.Stale.After != 0 || config.Stale.Action != "notify" {
+		t.Errorf("stale settings should be read from their prefixed names only; got %+v", config.Stale)
+	}
+	if config.Checker.RateLimit != 5 || config.Checker.Timeout != 10*time.Second || config.Checker.Concurrency != 4 {
+		t.Errorf("checker settings should be read from their prefixed names only; got %+v", config.Checker)
+	}
+	if strings.Join(config.Policy.DeniedDomains, ",") != "pastebin.com" {
+		t.Errorf("policy settings should be read from their prefixed names; got %+v", config.Policy)
+	}
+	if config.IDs.Length != 8 || config.IDs.Alphabet != "23456789bcdfghjkmnpqrstvwxyz" {
+		t.Errorf("ids settings should be read from their prefixed names only; got %+v", config.IDs)
+	}
+}
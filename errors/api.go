@@ -0,0 +1,137 @@
+package errors
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Code is a stable, machine readable identifier for a kind of error returned by the api.
+// Clients may depend on codes so they must not change once released.
+type Code string
+
+const (
+	// CodeInvalidRequest is returned when a request body could not be parsed
+	CodeInvalidRequest Code = "invalid_request"
+	// CodeInvalidID is returned when a short name is empty, too long or uses disallowed characters
+	CodeInvalidID Code = "invalid_id"
+	// CodeInvalidURL is returned when a link's url is missing or malformed
+	CodeInvalidURL Code = "invalid_url"
+	// CodeReservedID is returned when a short name is reserved for the app or through configuration
+	CodeReservedID Code = "reserved_id"
+	// CodeURLNotAllowed is returned when a link's url is rejected by the configured url policy
+	CodeURLNotAllowed Code = "url_not_allowed"
+	// CodeRedirectLoop is returned when a link would redirect back to itself, directly or through
+	// other links on this server, or through more of them than allowed
+	CodeRedirectLoop Code = "redirect_loop"
+	// CodeLinkInactive is returned when following a link outside of the window its schedule is active in
+	CodeLinkInactive Code = "link_inactive"
+	// CodeTooManyHops is returned when following a link passes through more links on this server
+	// than allowed, which can happen if a loop was created before loops were checked for
+	CodeTooManyHops Code = "too_many_hops"
+	// CodeUnauthorized is returned when a valid auth token is required but was not given
+	CodeUnauthorized Code = "unauthorized"
+	// CodeLinkNotFound is returned when no link exists with the requested short name
+	CodeLinkNotFound Code = "link_not_found"
+	// CodeLinkExists is returned when creating a link with a short name already in use
+	CodeLinkExists Code = "link_exists"
+	// CodeLinkArchived is returned when following a link that was archived for going unused
+	CodeLinkArchived Code = "link_archived"
+	// CodeNotSupported is returned when the configured storage engine can't perform an operation
+	CodeNotSupported Code = "not_supported"
+	// CodeStorageUnavailable is returned when the storage engine could not be reached
+	CodeStorageUnavailable Code = "storage_unavailable"
+	// CodeInternal is returned for any unexpected failure
+	CodeInternal Code = "internal"
+)
+
+// statuses maps each code to the http status it is returned with
+var statuses = map[Code]int{
+	CodeInvalidRequest:     http.StatusBadRequest,
+	CodeInvalidID:          http.StatusBadRequest,
+	CodeInvalidURL:         http.StatusBadRequest,
+	CodeReservedID:         http.StatusBadRequest,
+	CodeURLNotAllowed:      http.StatusBadRequest,
+	CodeRedirectLoop:       http.StatusBadRequest,
+	CodeUnauthorized:       http.StatusUnauthorized,
+	CodeLinkNotFound:       http.StatusNotFound,
+	CodeLinkExists:         http.StatusConflict,
+	CodeLinkArchived:       http.StatusGone,
+	CodeLinkInactive:       http.StatusNotFound,
+	CodeTooManyHops:        http.StatusLoopDetected,
+	CodeNotSupported:       http.StatusNotImplemented,
+	CodeStorageUnavailable: http.StatusServiceUnavailable,
+	CodeInternal:           http.StatusInternalServerError,
+}
+
+// FieldError describes why a single field of a request is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+}
+
+// APIError is the error envelope returned to api clients
+type APIError struct {
+	Code      Code         `json:"code"`
+	Message   string       `json:"message"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+
+	// cause is the underlying error, which is kept for logging but never sent to clients
+	cause error
+}
+
+// New creates an api error with the given code and a message safe to show to clients
+func New(code Code, message string, details ...FieldError) *APIError {
+	return &APIError{Code: code, Message: message, Details: details}
+}
+
+// Wrap creates an api error for an underlying error whose message should not reach clients
+func Wrap(code Code, message string, cause error) *APIError {
+	return &APIError{Code: code, Message: message, cause: cause}
+}
+
+// Error returns the message along with the underlying cause, if any
+func (e *APIError) Error() string {
+	if e.cause == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.cause.Error()
+}
+
+// Unwrap returns the underlying cause
+func (e *APIError) Unwrap() error {
+	return e.cause
+}
+
+// Is allows api errors to be matched against the storage errors they correspond to, so that
+// callers can check for a missing or existing link the same way on either side of the api.
+func (e *APIError) Is(target error) bool {
+	switch e.Code {
+	case CodeLinkNotFound:
+		return target == ErrNotFound
+	case CodeLinkExists:
+		return target == ErrExists
+	default:
+		return false
+	}
+}
+
+// Status returns the http status the error should be returned with
+func (e *APIError) Status() int {
+	status, ok := statuses[e.Code]
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+// ToAPIError returns err as an api error. Errors that aren't api errors are treated as
+// internal failures so that their messages aren't leaked to clients.
+func ToAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return Wrap(CodeInternal, "internal error", err)
+}
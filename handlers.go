@@ -1,26 +1,52 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	utilErrors "github.com/clintjedwards/goto/errors"
 	"github.com/clintjedwards/goto/models"
+	"github.com/clintjedwards/goto/storage"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
 )
 
-func (app *app) listLinksHandler(w http.ResponseWriter, _ *http.Request) {
-	links, err := app.storage.GetAllLinks()
+// statusCheckTimeout is how long the storage engine has to respond to a readiness check
+const statusCheckTimeout = 2 * time.Second
+
+// listLinksHandler returns all links, or only those with every tag given by the tag parameter
+func (app *app) listLinksHandler(w http.ResponseWriter, req *http.Request) {
+	tags := models.NormalizeTags(req.URL.Query()["tag"])
+
+	var links map[string]models.Link
+	var err error
+	if len(tags) == 0 {
+		links, err = app.storage.GetAllLinks(req.Context())
+	} else {
+		links, err = app.storage.GetLinksByTag(req.Context(), tags[0])
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("error retrieving links")
-		sendErrResponse(w, http.StatusBadGateway, err)
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve links", err))
 		return
 	}
 
+	// Only the first tag is looked up through the index; links are filtered on the rest
+	for id, link := range links {
+		for _, tag := range tags {
+			if !link.HasTag(tag) {
+				delete(links, id)
+				break
+			}
+		}
+	}
+
 	sendResponse(w, http.StatusOK, links)
 }
 
@@ -30,27 +56,62 @@ func (app *app) createLinkHandler(w http.ResponseWriter, req *http.Request) {
 	err := parseJSON(req.Body, &proposedLink)
 	if err != nil {
 		log.Warn().Err(err).Msg("could not parse json")
-		sendErrResponse(w, http.StatusBadRequest, err)
+		sendErrResponse(w, req, utilErrors.New(utilErrors.CodeInvalidRequest, err.Error()))
 		return
 	}
 	req.Body.Close()
 
-	err = proposedLink.Validate(app.config.MaxIDLength, req.Host)
+	generated := proposedLink.ID == ""
+	if generated {
+		proposedLink.ID, err = app.generateID(req.Context())
+		if err != nil {
+			log.Error().Err(err).Msg("could not generate id")
+			sendErrResponse(w, req, err)
+			return
+		}
+	}
+
+	err = proposedLink.Validate(app.currentConfig().MaxIDLength, app.reservedIDs(), app.urlPolicy())
 	if err != nil {
 		log.Error().Err(err).Msg("id or url invalid")
-		sendErrResponse(w, http.StatusBadRequest, err)
+		sendErrResponse(w, req, err)
 		return
 	}
 
 	newLink := proposedLink.ToLink()
 
-	err = app.storage.CreateLink(newLink)
+	err = app.checkRedirectChain(req.Context(), newLink, req.Host)
+	if err != nil {
+		log.Error().Err(err).Msg("link redirects back to itself")
+		sendErrResponse(w, req, err)
+		return
+	}
+
+	err = app.storage.CreateLink(req.Context(), newLink)
+
+	// Generated ids were free when picked but can be taken by a concurrent create before this
+	// one, which isn't a conflict the caller can do anything about, so another id is picked
+	for attempt := 1; generated && errors.Is(err, utilErrors.ErrExists) && attempt < maxIDAttempts; attempt++ {
+		newLink.ID, err = app.generateID(req.Context())
+		if err == nil {
+			err = app.checkRedirectChain(req.Context(), newLink, req.Host)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("could not generate id")
+			sendErrResponse(w, req, err)
+			return
+		}
+
+		err = app.storage.CreateLink(req.Context(), newLink)
+	}
+
 	if err != nil {
 		if errors.Is(err, utilErrors.ErrExists) {
-			sendErrResponse(w, http.StatusConflict, err)
+			sendErrResponse(w, req, utilErrors.New(utilErrors.CodeLinkExists, "a link with this id already exists"))
 			return
 		}
-		sendErrResponse(w, http.StatusNotFound, err)
+		log.Error().Err(err).Msg("could not create link")
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not create link", err))
 		return
 	}
 
@@ -74,35 +135,88 @@ func isReservedCharacter(c rune) bool {
 
 func (app *app) followLinkHandler(w http.ResponseWriter, req *http.Request) {
 	splitURL := strings.FieldsFunc(req.RequestURI[1:], isReservedCharacter)
+	if len(splitURL) == 0 {
+		redirectsTotal.WithLabelValues(string(redirectNotFound)).Inc()
+		sendErrResponse(w, req, utilErrors.New(utilErrors.CodeLinkNotFound, "link not found"))
+		return
+	}
 	linkID := splitURL[0]
 
-	link, err := app.storage.GetLink(linkID)
+	link, err := app.storage.GetLink(req.Context(), linkID)
 	if err != nil {
 		if errors.Is(err, utilErrors.ErrNotFound) {
-			sendErrResponse(w, http.StatusNotFound, err)
+			redirectsTotal.WithLabelValues(string(redirectNotFound)).Inc()
+			sendErrResponse(w, req, utilErrors.New(utilErrors.CodeLinkNotFound, "link not found"))
 			return
 		}
+		redirectsTotal.WithLabelValues(string(redirectError)).Inc()
 		log.Error().Err(err).Msg("error retrieving link")
-		sendErrResponse(w, http.StatusBadGateway, err)
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve link", err))
 		return
 	}
 
-	returnedLink := ""
+	if link.Archived != 0 {
+		redirectsTotal.WithLabelValues(string(redirectArchived)).Inc()
+		sendErrResponse(w, req, utilErrors.New(utilErrors.CodeLinkArchived, "link was archived after going unused"))
+		return
+	}
 
-	if link.Kind == models.Formatted {
-		returnedLink = generateFormattedLink(req.RequestURI[1:], link.URL)
-	} else {
-		returnedLink = link.URL + req.RequestURI[len(linkID)+1:]
+	now := time.Now()
+	if link.Schedule.Expired(now) {
+		redirectsTotal.WithLabelValues(string(redirectExpired)).Inc()
+		sendErrResponse(w, req, utilErrors.New(utilErrors.CodeLinkInactive, "link has expired"))
+		return
 	}
 
-	// We wrap this so we can spit out the error to logs
-	go func() {
-		err := app.storage.BumpHitCount(link.ID)
-		if err != nil {
-			log.Error().Err(err).Msg("could not increment hit count")
+	if !link.Schedule.Active(now) {
+		redirectsTotal.WithLabelValues(string(redirectInactive)).Inc()
+		sendErrResponse(w, req, utilErrors.New(utilErrors.CodeLinkInactive, "link is not active at this time"))
+		return
+	}
+
+	visitor := app.visitor(req)
+	link, destination := routedLink(app.scheduledLink(failedOverLink(link), now), visitor)
+	returnedLink := expandLink(link, req.RequestURI)
+
+	// Links pointing at other links on this server are followed here rather than by the client,
+	// which also stops loops created before they were checked for from bouncing forever
+	followed := []models.Link{link}
+	destinations := []int{destination}
+	for {
+		id, requestURI, found := app.linkTarget(returnedLink, req.Host)
+		if !found {
+			break
+		}
+
+		next, err := app.storage.GetLink(req.Context(), id)
+		if err != nil || next.Archived != 0 || !next.Schedule.Active(now) {
+			// Leave the client to follow it and be told why it doesn't work
+			break
 		}
-	}()
 
+		if len(followed) > app.currentConfig().MaxHops {
+			redirectsTotal.WithLabelValues(string(redirectTooManyHops)).Inc()
+			log.Warn().Str("id", link.ID).Str("url", returnedLink).Msg("link redirects through too many links")
+			sendErrResponse(w, req, utilErrors.New(utilErrors.CodeTooManyHops,
+				"link redirects through too many other links"))
+			return
+		}
+
+		next, destination = routedLink(app.scheduledLink(failedOverLink(next), now), visitor)
+		followed = append(followed, next)
+		destinations = append(destinations, destination)
+		returnedLink = expandLink(next, requestURI)
+	}
+
+	visit := app.visitFrom(req)
+	for i, link := range followed {
+		app.hits.record(req.Context(), link.ID, visit)
+		if destinations[i] >= 0 {
+			app.hits.recordDestination(req.Context(), link.ID, destinations[i])
+		}
+	}
+
+	redirectsTotal.WithLabelValues(string(redirectFound)).Inc()
 	http.Redirect(w, req, returnedLink, http.StatusMovedPermanently)
 }
 
@@ -116,14 +230,14 @@ func generateFormattedLink(input string, link string) string {
 
 func (app *app) getLinkHandler(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
-	link, err := app.storage.GetLink(vars["id"])
+	link, err := app.storage.GetLink(req.Context(), vars["id"])
 	if err != nil {
 		if errors.Is(err, utilErrors.ErrNotFound) {
-			sendErrResponse(w, http.StatusNotFound, err)
+			sendErrResponse(w, req, utilErrors.New(utilErrors.CodeLinkNotFound, "link not found"))
 			return
 		}
 		log.Error().Err(err).Msg("error retrieving link")
-		sendErrResponse(w, http.StatusBadGateway, err)
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve link", err))
 		return
 	}
 
@@ -133,10 +247,10 @@ func (app *app) getLinkHandler(w http.ResponseWriter, req *http.Request) {
 func (app *app) deleteLinksHandler(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 
-	err := app.storage.DeleteLink(vars["id"])
+	err := app.storage.DeleteLink(req.Context(), vars["id"])
 	if err != nil {
 		log.Error().Err(err).Msg("could not delete link")
-		sendErrResponse(w, http.StatusBadGateway, err)
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not delete link", err))
 		return
 	}
 
@@ -144,6 +258,58 @@ func (app *app) deleteLinksHandler(w http.ResponseWriter, req *http.Request) {
 	sendResponse(w, http.StatusOK, nil)
 }
 
+func (app *app) backupHandler(w http.ResponseWriter, req *http.Request) {
+	snapshotter, ok := app.storage.(storage.Snapshotter)
+	if !ok {
+		sendErrResponse(w, req, utilErrors.New(utilErrors.CodeNotSupported, "storage engine does not support backups"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", backupFileName(time.Now())))
+
+	size, err := snapshotter.Snapshot(req.Context(), w)
+	if err != nil {
+		// The response has likely already started so all we can do is log the failure
+		log.Error().Err(err).Msg("could not stream backup")
+		return
+	}
+
+	log.Info().Int64("bytes", size).Msg("streamed backup")
+}
+
+// healthHandler reports that the process is up and able to serve requests
+func (app *app) healthHandler(w http.ResponseWriter, _ *http.Request) {
+	sendResponse(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// statusHandler reports whether the app is ready to serve links by checking
+// that the storage engine can be reached
+func (app *app) statusHandler(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), statusCheckTimeout)
+	defer cancel()
+
+	err := app.storage.Ping(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("storage engine unavailable")
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "storage engine unavailable", err))
+		return
+	}
+
+	sendResponse(w, http.StatusOK, map[string]string{
+		"status":         "ok",
+		"storage_engine": app.currentConfig().Database.Engine,
+	})
+}
+
+func (app *app) versionHandler(w http.ResponseWriter, _ *http.Request) {
+	sendResponse(w, http.StatusOK, map[string]string{
+		"version":        version,
+		"commit":         commit,
+		"storage_engine": app.currentConfig().Database.Engine,
+	})
+}
+
 // sendResponse converts raw objects and parameters to a json response
 // and passes it to a provided writer.
 func sendResponse(w http.ResponseWriter, httpStatusCode int, payload interface{}) {
@@ -159,11 +325,18 @@ func sendResponse(w http.ResponseWriter, httpStatusCode int, payload interface{}
 // sendErrResponse converts raw objects and parameters to a json response specifically for erorrs
 // and passes it to a provided writer. The creation of a separate function for just errors,
 // is due to how they are handled differently from other payload types.
-func sendErrResponse(w http.ResponseWriter, httpStatusCode int, appErr error) {
-	w.WriteHeader(httpStatusCode)
+//
+// The status and error code are taken from appErr. Errors that aren't api errors are reported
+// as internal errors without their message.
+func sendErrResponse(w http.ResponseWriter, req *http.Request, appErr error) {
+	apiErr := *utilErrors.ToAPIError(appErr)
+	apiErr.RequestID = requestID(req.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status())
 
 	enc := json.NewEncoder(w)
-	err := enc.Encode(map[string]string{"err": appErr.Error()})
+	err := enc.Encode(map[string]interface{}{"error": apiErr})
 	if err != nil {
 		log.Error().Err(err).Msgf("could not encode json response: %v", err)
 	}
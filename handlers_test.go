@@ -1,6 +1,17 @@
 package main
 
-import "testing"
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/clintjedwards/goto/config"
+	utilErrors "github.com/clintjedwards/goto/errors"
+)
 
 func TestGenerateFormattedLink(t *testing.T) {
 	tests := map[string]struct {
@@ -44,3 +55,65 @@ func TestGenerateFormattedLink(t *testing.T) {
 		})
 	}
 }
+
+func TestStatusHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goto.db")
+	engine, err := initStorage(&config.DatabaseConfig{Engine: "bolt", Bolt: &config.BoltConfig{Path: path}})
+	if err != nil {
+		t.Fatalf("could not create bolt engine: %v", err)
+	}
+
+	app := &app{storage: engine}
+	app.config.Store(&config.Config{Database: &config.DatabaseConfig{Engine: "bolt"}})
+
+	recorder := httptest.NewRecorder()
+	app.statusHandler(recorder, httptest.NewRequest("GET", "/status", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("status should be ok with a healthy storage engine; got %d", recorder.Code)
+	}
+
+	// Removing the database file out from under the engine should make it unready
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatalf("could not remove database file: %v", err)
+	}
+
+	recorder = httptest.NewRecorder()
+	app.statusHandler(recorder, httptest.NewRequest("GET", "/status", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("status should be unavailable without a database file; got %d", recorder.Code)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	router, err := newTestApp(t).newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/v1/links", strings.NewReader(`{"id": "links", "url": "https://github.com"}`))
+	req.Header.Set(requestIDHeader, "abc-123")
+	router.ServeHTTP(recorder, req)
+
+	var response struct {
+		Error utilErrors.APIError `json:"error"`
+	}
+	err = json.NewDecoder(recorder.Body).Decode(&response)
+	if err != nil {
+		t.Fatalf("could not decode error response: %v", err)
+	}
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status; want %d; got %d", http.StatusBadRequest, recorder.Code)
+	}
+	if response.Error.Code != utilErrors.CodeReservedID {
+		t.Errorf("unexpected error code; want %q; got %q", utilErrors.CodeReservedID, response.Error.Code)
+	}
+	if len(response.Error.Details) != 1 || response.Error.Details[0].Field != "id" {
+		t.Errorf("expected a single detail for the id field; got %v", response.Error.Details)
+	}
+	if response.Error.RequestID != "abc-123" || recorder.Header().Get(requestIDHeader) != "abc-123" {
+		t.Errorf("client supplied request id should be returned; got %q", response.Error.RequestID)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/clintjedwards/goto/models"
+	"github.com/clintjedwards/goto/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// hitRecorder updates hit counts in the background so that redirects are not slowed down
+// by storage writes, while keeping track of pending updates so they can be finished before exit.
+type hitRecorder struct {
+	storage storage.Engine
+	pending sync.WaitGroup
+}
+
+func newHitRecorder(storage storage.Engine) *hitRecorder {
+	return &hitRecorder{storage: storage}
+}
+
+// record schedules the hit count for the given link to be incremented, along with the counts
+// for where the visit came from. The update outlives the request, so ctx is only used for its values.
+func (h *hitRecorder) record(ctx context.Context, id string, visit models.Visit) {
+	ctx = context.WithoutCancel(ctx)
+	at := time.Now()
+
+	h.pending.Add(1)
+	hitRecorderBacklog.Inc()
+
+	// We wrap this so we can spit out the error to logs
+	go func() {
+		defer h.pending.Done()
+		defer hitRecorderBacklog.Dec()
+
+		err := h.storage.BumpHitCount(ctx, id, at)
+		if err != nil {
+			log.Error().Err(err).Str("id", id).Msg("could not increment hit count")
+			return
+		}
+
+		if len(visit.Values()) == 0 {
+			return
+		}
+
+		err = h.storage.RecordVisit(ctx, id, visit)
+		if err != nil {
+			log.Error().Err(err).Str("id", id).Msg("could not record visit")
+		}
+	}()
+}
+
+// recordDestination schedules the hit count of one of a routed link's destinations to be incremented
+func (h *hitRecorder) recordDestination(ctx context.Context, id string, destination int) {
+	ctx = context.WithoutCancel(ctx)
+
+	h.pending.Add(1)
+	hitRecorderBacklog.Inc()
+
+	go func() {
+		defer h.pending.Done()
+		defer hitRecorderBacklog.Dec()
+
+		err := h.storage.UpdateLink(ctx, id, func(link *models.Link) error {
+			// The link may have been replaced since it was followed
+			if destination < len(link.Destinations) {
+				link.Destinations[destination].Hits++
+			}
+			return nil
+		})
+		if err != nil {
+			log.Error().Err(err).Str("id", id).Int("destination", destination).Msg("could not increment destination hit count")
+		}
+	}()
+}
+
+// drain waits until all pending updates have been written or ctx expires.
+// It should only be called once no more hits are being recorded.
+func (h *hitRecorder) drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
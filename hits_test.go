@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/clintjedwards/goto/models"
+)
+
+func TestHitRecorderDrain(t *testing.T) {
+	engine := newTestBoltEngine(t, "goto.db")
+
+	err := engine.CreateLink(context.Background(), &models.Link{ID: "github", URL: "https://github.com"})
+	if err != nil {
+		t.Fatalf("could not create link: %v", err)
+	}
+
+	hits := newHitRecorder(engine)
+	for i := 0; i < 10; i++ {
+		hits.record(context.Background(), "github", models.Visit{})
+	}
+
+	err = hits.drain(context.Background())
+	if err != nil {
+		t.Fatalf("could not drain hit recorder: %v", err)
+	}
+
+	link, err := engine.GetLink(context.Background(), "github")
+	if err != nil {
+		t.Fatalf("could not retrieve link: %v", err)
+	}
+	if link.Hits != 10 {
+		t.Errorf("all pending hits should be recorded after draining; want 10; got %d", link.Hits)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"math/big"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxIDAttempts is how many random short names are tried before giving up on finding a free one
+	maxIDAttempts = 10
+	// defaultSuggestionLimit is how many short names are suggested for a url
+	defaultSuggestionLimit = 5
+	// titleTimeout is how long a destination has to respond when fetching its title
+	titleTimeout = 5 * time.Second
+	// maxTitleBody is the most of a page read when looking for its title
+	maxTitleBody = 256 * 1024
+)
+
+// blockedWords are never part of a generated short name, no matter the configured alphabet
+var blockedWords = []string{
+	"anal", "anus", "arse", "cock", "coon", "crap", "cunt", "dick", "dyke", "fag", "fuck", "jizz",
+	"kike", "nazi", "nigg", "penis", "piss", "porn", "rape", "shit", "slut", "spic", "tits", "twat",
+	"wank", "whore",
+}
+
+// stopWords are left out of short names suggested from titles
+var stopWords = map[string]struct{}{
+	"a": {}, "an": {}, "and": {}, "at": {}, "by": {}, "for": {}, "from": {}, "how": {}, "in": {},
+	"is": {}, "of": {}, "on": {}, "or": {}, "the": {}, "to": {}, "with": {},
+}
+
+// ignoredSegments are path segments that say nothing about a page
+var ignoredSegments = map[string]struct{}{
+	"index": {}, "default": {}, "home": {}, "main": {}, "master": {}, "blob": {}, "tree": {},
+	"wiki": {}, "page": {}, "pages": {}, "view": {}, "display": {}, "en": {}, "en-us": {},
+}
+
+var (
+	// titlePattern finds the title of an html page
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	// slugSeparators are runs of characters that can't be part of a short name
+	slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+	// opaqueSegment matches path segments that are identifiers rather than words, such as
+	// numbers, hashes and uuids
+	opaqueSegment = regexp.MustCompile(`^([0-9]+|[0-9a-f]{12,}|[0-9a-f-]{32,})$`)
+)
+
+// generateID returns a random short name made from the configured alphabet that isn't reserved,
+// doesn't contain a blocked word and isn't taken by an existing link
+func (app *app) generateID(ctx context.Context) (string, error) {
+	settings := app.currentConfig().IDs
+	reserved := app.reservedIDs()
+
+	for attempt := 0; attempt < maxIDAttempts; attempt++ {
+		id, err := randomID(settings.Alphabet, settings.Length)
+		if err != nil {
+			return "", utilErrors.Wrap(utilErrors.CodeInternal, "could not generate id", err)
+		}
+
+		if slices.Contains(reserved, id) || containsBlockedWord(id, settings.BlockedWords) {
+			continue
+		}
+
+		_, err = app.storage.GetLink(ctx, id)
+		if errors.Is(err, utilErrors.ErrNotFound) {
+			return id, nil
+		}
+		if err != nil {
+			return "", utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve link", err)
+		}
+	}
+
+	return "", utilErrors.New(utilErrors.CodeInternal, "could not find an unused id; the configured ids length may be too short")
+}
+
+// randomID returns length characters picked at random from alphabet
+func randomID(alphabet string, length int) (string, error) {
+	characters := []rune(alphabet)
+	max := big.NewInt(int64(len(characters)))
+
+	id := make([]rune, length)
+	for i := range id {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		id[i] = characters[n.Int64()]
+	}
+
+	return string(id), nil
+}
+
+// containsBlockedWord reports whether id contains any of the built in or configured blocked words
+func containsBlockedWord(id string, configured []string) bool {
+	id = strings.ToLower(id)
+	for _, word := range append(append([]string{}, blockedWords...), configured...) {
+		if word != "" && strings.Contains(id, strings.ToLower(word)) {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestionRequest is a url to suggest short names for. The title of the page may be given to
+// suggest names from; it is otherwise fetched when enabled.
+type suggestionRequest struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+}
+
+// suggestionResult is the short names suggested for a url, best first
+type suggestionResult struct {
+	URL         string   `json:"url"`
+	Title       string   `json:"title,omitempty"`
+	Suggestions []string `json:"suggestions"`
+}
+
+// suggestIDsHandler proposes readable, unused short names for a url without creating a link
+func (app *app) suggestIDsHandler(w http.ResponseWriter, req *http.Request) {
+	request := suggestionRequest{}
+
+	err := parseJSON(req.Body, &request)
+	if err != nil {
+		log.Warn().Err(err).Msg("could not parse json")
+		sendErrResponse(w, req, utilErrors.New(utilErrors.CodeInvalidRequest, err.Error()))
+		return
+	}
+	req.Body.Close()
+
+	parsed, err := url.Parse(request.URL)
+	if request.URL == "" || err != nil || parsed.Host == "" {
+		sendErrResponse(w, req, utilErrors.New(utilErrors.CodeInvalidURL, "url must be a valid absolute url"))
+		return
+	}
+
+	var violation *models.PolicyViolation
+	if errors.As(app.urlPolicy().Check(request.URL), &violation) {
+		sendErrResponse(w, req, utilErrors.New(utilErrors.CodeURLNotAllowed, violation.Message))
+		return
+	}
+
+	title := request.Title
+	if title == "" && app.currentConfig().IDs.FetchTitles {
+		checker := newLinkChecker(app.storage, app.currentConfig().Checker)
+		title = fetchTitle(req.Context(), request.URL, checker, newTitleClient(checker, publicAddressesOnly))
+	}
+
+	result := suggestionResult{URL: request.URL, Title: title, Suggestions: []string{}}
+
+	maxLength := app.currentConfig().MaxIDLength
+	reserved := app.reservedIDs()
+	for _, candidate := range suggestIDs(parsed, title) {
+		candidate = truncateSlug(candidate, maxLength)
+		if candidate == "" || slices.Contains(reserved, candidate) || slices.Contains(result.Suggestions, candidate) {
+			continue
+		}
+
+		_, err := app.storage.GetLink(req.Context(), candidate)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, utilErrors.ErrNotFound) {
+			log.Error().Err(err).Msg("error retrieving link")
+			sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve link", err))
+			return
+		}
+
+		result.Suggestions = append(result.Suggestions, candidate)
+		if len(result.Suggestions) == defaultSuggestionLimit {
+			break
+		}
+	}
+
+	sendResponse(w, http.StatusOK, result)
+}
+
+// suggestIDs returns short names describing destination, best first. Names come from the page
+// title, the last parts of the path and the site's name.
+func suggestIDs(destination *url.URL, title string) []string {
+	candidates := []string{}
+
+	// Titles often end with the name of the site, ex. "Getting started - Docs"
+	for _, separator := range []string{" | ", " - ", " — ", " · "} {
+		title, _, _ = strings.Cut(title, separator)
+	}
+	if words := slugWords(title, true); len(words) > 0 {
+		candidates = append(candidates, strings.Join(words[:min(3, len(words))], "-"))
+	}
+
+	segments := []string{}
+	for _, segment := range strings.Split(destination.Path, "/") {
+		segment = strings.TrimSuffix(segment, path.Ext(segment))
+		slug := strings.Join(slugWords(segment, false), "-")
+		if _, ignored := ignoredSegments[slug]; ignored || slug == "" || opaqueSegment.MatchString(slug) {
+			continue
+		}
+		segments = append(segments, slug)
+	}
+
+	site := siteName(destination.Hostname())
+
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+		candidates = append(candidates, last)
+		if len(segments) > 1 {
+			candidates = append(candidates, segments[len(segments)-2]+"-"+last)
+		}
+		if site != "" {
+			candidates = append(candidates, site+"-"+last)
+		}
+	}
+
+	if site != "" {
+		candidates = append(candidates, site)
+	}
+
+	return candidates
+}
+
+// slugWords lowercases s and splits it into the words a short name can be made of
+func slugWords(s string, dropStopWords bool) []string {
+	words := []string{}
+	for _, word := range slugSeparators.Split(strings.ToLower(s), -1) {
+		if word == "" {
+			continue
+		}
+		if _, stop := stopWords[word]; stop && dropStopWords {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words
+}
+
+// siteName returns the part of a host naming the site, ex. github for www.github.com
+func siteName(host string) string {
+	labels := strings.Split(strings.ToLower(host), ".")
+	if len(labels) > 1 {
+		labels = labels[:len(labels)-1]
+	}
+	if len(labels) > 1 && labels[0] == "www" {
+		labels = labels[1:]
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+	return strings.Join(slugWords(labels[len(labels)-1], false), "-")
+}
+
+// truncateSlug shortens slug to at most length characters, cutting between words when possible
+func truncateSlug(slug string, length int) string {
+	if len(slug) <= length {
+		return slug
+	}
+	if slug[length] == '-' {
+		return slug[:length]
+	}
+	slug = slug[:length]
+	if cut := strings.LastIndex(slug, "-"); cut > 0 {
+		slug = slug[:cut]
+	}
+	return strings.Trim(slug, "-")
+}
+
+// newTitleClient returns a client for fetching page titles. Pages are requested on behalf of
+// whoever asks for suggestions, so like the link checker the client only follows redirects to hosts
+// checker may request, and control can refuse connections to addresses that must not be reached.
+func newTitleClient(checker *linkChecker, control func(network, address string, conn syscall.RawConn) error) *http.Client {
+	dialer := &net.Dialer{Timeout: titleTimeout, Control: control}
+
+	return &http.Client{
+		Timeout:       titleTimeout,
+		CheckRedirect: checker.checkRedirect,
+		// No proxy, since connections made through one couldn't be checked
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: titleTimeout,
+			DisableKeepAlives:   true,
+		},
+	}
+}
+
+// publicAddressesOnly refuses connections to loopback, private, link-local and unspecified
+// addresses. It runs once the destination's name is resolved, so names pointing at such
+// addresses are refused too.
+func publicAddressesOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%s is not a public address", ip)
+	}
+
+	return nil
+}
+
+// fetchTitle returns the title of the html page at destination, or nothing if it can't be found.
+// Only destinations checker may request are fetched.
+func fetchTitle(ctx context.Context, destination string, checker *linkChecker, client *http.Client) string {
+	if !checker.allowed(destination) {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, titleTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, destination, nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("User-Agent", checkerUserAgent)
+
+	response, err := client.Do(req)
+	if err != nil {
+		log.Debug().Err(err).Str("url", destination).Msg("could not fetch title")
+		return ""
+	}
+	defer response.Body.Close()
+
+	// Redirects that weren't followed are left as they are, and have no title worth using
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if response.StatusCode < 200 || response.StatusCode >= 300 || mediaType != "text/html" {
+		return ""
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxTitleBody))
+	if err != nil {
+		return ""
+	}
+
+	match := titlePattern.FindSubmatch(body)
+	if match == nil {
+		return ""
+	}
+
+	return strings.Join(strings.Fields(html.UnescapeString(string(match[1]))), " ")
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clintjedwards/goto/config"
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+	"github.com/clintjedwards/goto/storage"
+)
+
+func TestGenerateID(t *testing.T) {
+	app := newTestApp(t)
+	app.config.Load().IDs.Alphabet = "ab"
+	app.config.Load().IDs.Length = 1
+
+	err := app.storage.CreateLink(context.Background(), &models.Link{ID: "a", URL: "https://github.com"})
+	if err != nil {
+		t.Fatalf("could not create link: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		id, err := app.generateID(context.Background())
+		if err != nil {
+			t.Fatalf("could not generate id: %v", err)
+		}
+		if id != "b" {
+			t.Errorf("generated id should avoid existing links; got %q", id)
+		}
+	}
+
+	app.config.Load().ReservedIDs = []string{"b"}
+	if id, err := app.generateID(context.Background()); err == nil {
+		t.Errorf("generating should fail when every id is taken; got %q", id)
+	}
+}
+
+func TestContainsBlockedWord(t *testing.T) {
+	tests := map[string]bool{
+		"x7crapq": true,
+		"X7CRAPQ": true,
+		"b4nk3r":  false,
+		"acme42":  true,
+	}
+
+	for id, want := range tests {
+		if got := containsBlockedWord(id, []string{"acme"}); got != want {
+			t.Errorf("unexpected result for %q; want %v; got %v", id, want, got)
+		}
+	}
+}
+
+func TestSuggestIDs(t *testing.T) {
+	tests := map[string]struct {
+		url   string
+		title string
+		want  []string
+	}{
+		"path and site": {
+			url:  "https://github.com/clintjedwards/goto",
+			want: []string{"goto", "clintjedwards-goto", "github-goto", "github"},
+		},
+		"title": {
+			url:   "https://www.example.com/docs/index.html",
+			title: "Getting Started with the API | Example Docs",
+			want:  []string{"getting-started-api", "docs", "example-docs", "example"},
+		},
+		"opaque segments": {
+			url:  "https://jira.example.com/browse/OPS/12345",
+			want: []string{"ops", "browse-ops", "example-ops", "example"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			parsed, err := url.Parse(tc.url)
+			if err != nil {
+				t.Fatalf("could not parse url: %v", err)
+			}
+
+			got := suggestIDs(parsed, tc.title)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("unexpected suggestions; want %v; got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestTruncateSlug(t *testing.T) {
+	if got := truncateSlug("getting-started-api", 12); got != "getting" {
+		t.Errorf("slug should be cut between words; got %q", got)
+	}
+	if got := truncateSlug(strings.Repeat("a", 20), 10); got != strings.Repeat("a", 10) {
+		t.Errorf("slug without words should be cut at the length; got %q", got)
+	}
+}
+
+// conflictingEngine reports the first creates as conflicts, as if another request had just
+// taken the id
+type conflictingEngine struct {
+	storage.Engine
+	conflicts int
+}
+
+func (e *conflictingEngine) CreateLink(ctx context.Context, link *models.Link) error {
+	if e.conflicts > 0 {
+		e.conflicts--
+		return utilErrors.ErrExists
+	}
+	return e.Engine.CreateLink(ctx, link)
+}
+
+func TestCreateLinkRetriesGeneratedID(t *testing.T) {
+	app := newTestApp(t)
+	engine := &conflictingEngine{Engine: app.storage, conflicts: 2}
+	app.storage = engine
+	router, err := app.newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	create := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("POST", apiVersionPath+"/links", strings.NewReader(body)))
+		return recorder
+	}
+
+	if recorder := create(`{"url": "https://github.com"}`); recorder.Code != http.StatusCreated {
+		t.Errorf("generated ids should be picked again when taken; got status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	engine.conflicts = 1
+	if recorder := create(`{"id": "docs", "url": "https://github.com"}`); recorder.Code != http.StatusConflict {
+		t.Errorf("requested ids that are taken should be a conflict; got status %d", recorder.Code)
+	}
+}
+
+func TestFetchTitle(t *testing.T) {
+	internalRequests := atomic.Int32{}
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		internalRequests.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<title>Internal admin</title>"))
+	}))
+	defer internal.Close()
+	internalURL := strings.Replace(internal.URL, "127.0.0.1", "localhost", 1)
+
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/escape" {
+			http.Redirect(w, req, internalURL, http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte("<html><head><title>\n  Getting &amp; started\n</title></head></html>"))
+	}))
+	defer page.Close()
+
+	checker := newLinkChecker(nil, &config.CheckerConfig{Timeout: time.Second, AllowedHosts: []string{"127.0.0.1"}})
+	client := newTitleClient(checker, nil)
+
+	if title := fetchTitle(context.Background(), page.URL+"/docs", checker, client); title != "Getting & started" {
+		t.Errorf("unexpected title; got %q", title)
+	}
+	if title := fetchTitle(context.Background(), page.URL+"/escape", checker, client); title != "" {
+		t.Errorf("redirects to hosts that aren't allowed should not be followed; got title %q", title)
+	}
+	if title := fetchTitle(context.Background(), internalURL, checker, client); title != "" {
+		t.Errorf("hosts that aren't allowed should not be requested; got title %q", title)
+	}
+	if requests := internalRequests.Load(); requests != 0 {
+		t.Errorf("host that isn't allowed was requested %d times", requests)
+	}
+
+	// Loopback addresses are refused when connecting, whatever hosts are allowed
+	checker = newLinkChecker(nil, &config.CheckerConfig{Timeout: time.Second})
+	if title := fetchTitle(context.Background(), page.URL+"/docs", checker, newTitleClient(checker, publicAddressesOnly)); title != "" {
+		t.Errorf("loopback addresses should not be requested; got title %q", title)
+	}
+}
+
+func TestPublicAddressesOnly(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34:443":     true,
+		"[2606:2800:220::1]:80": true,
+		"127.0.0.1:80":          false,
+		"10.0.0.5:80":           false,
+		"192.168.1.1:443":       false,
+		"169.254.169.254:80":    false,
+		"0.0.0.0:80":            false,
+		"[::1]:80":              false,
+		"[fd00::1]:80":          false,
+		"[::ffff:10.0.0.5]:80":  false,
+	}
+
+	for address, public := range tests {
+		if err := publicAddressesOnly("tcp", address, nil); (err == nil) != public {
+			t.Errorf("unexpected result for %s; want public %v; got %v", address, public, err)
+		}
+	}
+}
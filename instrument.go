@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+	"github.com/clintjedwards/goto/storage"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// routeTemplate returns the path template of the route matching the request, so that
+// instrumentation can group requests without the link ID leaking into names or labels.
+func routeTemplate(req *http.Request) string {
+	currentRoute := mux.CurrentRoute(req)
+	if currentRoute == nil {
+		return "unknown"
+	}
+
+	template, err := currentRoute.GetPathTemplate()
+	if err != nil {
+		return "unknown"
+	}
+
+	return template
+}
+
+// instrumentedEngine wraps a storage engine and records a span, latency and errors for each operation
+type instrumentedEngine struct {
+	engine     storage.Engine
+	engineType string
+}
+
+// instrumentedSnapshotter is an instrumentedEngine for engines that also support snapshots
+type instrumentedSnapshotter struct {
+	*instrumentedEngine
+	snapshotter storage.Snapshotter
+}
+
+// instrumentStorage wraps the given engine so that every call is measured. Optional
+// capabilities of the underlying engine, like snapshots, remain available on the result.
+func instrumentStorage(engine storage.Engine, engineType string) storage.Engine {
+	instrumented := &instrumentedEngine{engine: engine, engineType: engineType}
+
+	if snapshotter, ok := engine.(storage.Snapshotter); ok {
+		return &instrumentedSnapshotter{instrumentedEngine: instrumented, snapshotter: snapshotter}
+	}
+
+	return instrumented
+}
+
+// observe starts a span for a single storage operation and returns a function that
+// records its duration and outcome once the operation is complete.
+// Missing and duplicate entities are expected results rather than storage failures.
+func (e *instrumentedEngine) observe(ctx context.Context, operation string,
+	attrs ...attribute.KeyValue) (context.Context, func(error)) {
+	start := time.Now()
+
+	attrs = append(attrs, semconv.DBSystemKey.String(e.engineType), semconv.DBOperation(operation))
+	ctx, span := tracer.Start(ctx, "storage."+operation,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+
+	return ctx, func(err error) {
+		storageOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+
+		if err != nil && !errors.Is(err, utilErrors.ErrNotFound) && !errors.Is(err, utilErrors.ErrExists) {
+			storageOperationErrors.WithLabelValues(operation).Inc()
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		span.End()
+	}
+}
+
+func (e *instrumentedEngine) GetAllLinks(ctx context.Context) (map[string]models.Link, error) {
+	ctx, done := e.observe(ctx, "GetAllLinks")
+	links, err := e.engine.GetAllLinks(ctx)
+	done(err)
+	return links, err
+}
+
+func (e *instrumentedEngine) GetLink(ctx context.Context, id string) (models.Link, error) {
+	ctx, done := e.observe(ctx, "GetLink", attribute.String("link.id", id))
+	link, err := e.engine.GetLink(ctx, id)
+	done(err)
+	return link, err
+}
+
+func (e *instrumentedEngine) GetLinksByTag(ctx context.Context, tag string) (map[string]models.Link, error) {
+	ctx, done := e.observe(ctx, "GetLinksByTag", attribute.String("link.tag", tag))
+	links, err := e.engine.GetLinksByTag(ctx, tag)
+	done(err)
+	return links, err
+}
+
+func (e *instrumentedEngine) GetTags(ctx context.Context) (map[string]int64, error) {
+	ctx, done := e.observe(ctx, "GetTags")
+	tags, err := e.engine.GetTags(ctx)
+	done(err)
+	return tags, err
+}
+
+func (e *instrumentedEngine) CreateLink(ctx context.Context, link *models.Link) error {
+	ctx, done := e.observe(ctx, "CreateLink", attribute.String("link.id", link.ID))
+	err := e.engine.CreateLink(ctx, link)
+	done(err)
+	return err
+}
+
+func (e *instrumentedEngine) BumpHitCount(ctx context.Context, id string, at time.Time) error {
+	ctx, done := e.observe(ctx, "BumpHitCount", attribute.String("link.id", id))
+	err := e.engine.BumpHitCount(ctx, id, at)
+	done(err)
+	return err
+}
+
+func (e *instrumentedEngine) DeleteLink(ctx context.Context, id string) error {
+	ctx, done := e.observe(ctx, "DeleteLink", attribute.String("link.id", id))
+	err := e.engine.DeleteLink(ctx, id)
+	done(err)
+	return err
+}
+
+func (e *instrumentedEngine) UpdateLink(ctx context.Context, id string, update func(link *models.Link) error) error {
+	ctx, done := e.observe(ctx, "UpdateLink", attribute.String("link.id", id))
+	err := e.engine.UpdateLink(ctx, id, update)
+	done(err)
+	return err
+}
+
+func (e *instrumentedEngine) GetHits(ctx context.Context, id string, granularity models.Granularity,
+	from, to time.Time) ([]models.HitBucket, error) {
+	ctx, done := e.observe(ctx, "GetHits", attribute.String("link.id", id))
+	hits, err := e.engine.GetHits(ctx, id, granularity, from, to)
+	done(err)
+	return hits, err
+}
+
+func (e *instrumentedEngine) GetHitTotals(ctx context.Context, granularity models.Granularity,
+	from, to time.Time) (map[string]int64, error) {
+	ctx, done := e.observe(ctx, "GetHitTotals")
+	totals, err := e.engine.GetHitTotals(ctx, granularity, from, to)
+	done(err)
+	return totals, err
+}
+
+func (e *instrumentedEngine) RecordVisit(ctx context.Context, id string, visit models.Visit) error {
+	ctx, done := e.observe(ctx, "RecordVisit", attribute.String("link.id", id))
+	err := e.engine.RecordVisit(ctx, id, visit)
+	done(err)
+	return err
+}
+
+func (e *instrumentedEngine) GetVisits(ctx context.Context, id string) (map[models.VisitDimension]map[string]int64, error) {
+	ctx, done := e.observe(ctx, "GetVisits", attribute.String("link.id", id))
+	visits, err := e.engine.GetVisits(ctx, id)
+	done(err)
+	return visits, err
+}
+
+func (e *instrumentedEngine) Ping(ctx context.Context) error {
+	ctx, done := e.observe(ctx, "Ping")
+	err := e.engine.Ping(ctx)
+	done(err)
+	return err
+}
+
+func (e *instrumentedEngine) Close() error {
+	return e.engine.Close()
+}
+
+func (e *instrumentedSnapshotter) Snapshot(ctx context.Context, w io.Writer) (int64, error) {
+	ctx, done := e.observe(ctx, "Snapshot")
+	size, err := e.snapshotter.Snapshot(ctx, w)
+	done(err)
+	return size, err
+}
@@ -1,50 +1,157 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/clintjedwards/goto/config"
-	"github.com/gorilla/handlers"
-	"github.com/gorilla/mux"
+	"github.com/clintjedwards/goto/storage"
 	"github.com/rs/zerolog/log"
 )
 
+// version and commit are injected at build time through ldflags
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
 func main() {
-	config, err := config.FromEnv()
+	configPath := os.Getenv("GOTO_CONFIG")
+	config, err := config.Load(configPath)
 	if err != nil {
-		log.Fatal().Err(err).Msg("could not load env config")
+		log.Fatal().Err(err).Msg("could not load config")
 	}
 
 	setupLogging(config.LogLevel, config.Debug)
 
-	app := newApp()
-	router := mux.NewRouter()
+	if len(os.Args) > 1 {
+		command, args := os.Args[1], os.Args[2:]
 
-	router.Handle("/links", handlers.MethodHandler{
-		"GET": http.HandlerFunc(app.listLinksHandler),
-	})
+		if linkCommand, ok := findLinkCommand(command); ok {
+			err := runLinkCommand(config, linkCommand, args, os.Stdout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "goto %s: %v\n", command, err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		switch command {
+		case "server":
+			// Same as running without a command
+		case "migrate":
+			err := runMigrate(config, args)
+			if err != nil {
+				log.Fatal().Err(err).Msg("migration failed")
+			}
+			return
+		case "backup":
+			err := runBackup(config, args)
+			if err != nil {
+				log.Fatal().Err(err).Msg("backup failed")
+			}
+			return
+		case "restore":
+			err := runRestore(config, args)
+			if err != nil {
+				log.Fatal().Err(err).Msg("restore failed")
+			}
+			return
+		case "completion":
+			err := runCompletion(args, os.Stdout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "goto completion: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "help", "-h", "--help":
+			printUsage(os.Stdout)
+			return
+		default:
+			printUsage(os.Stderr)
+			os.Exit(2)
+		}
+	}
 
-	router.Handle("/links/{id}", handlers.MethodHandler{
-		"GET":    http.HandlerFunc(app.getLinkHandler),
-		"DELETE": http.HandlerFunc(app.deleteLinksHandler),
-	})
+	runServer(config, configPath)
+}
 
-	router.Handle("/create", handlers.MethodHandler{
-		"POST": http.HandlerFunc(app.createLinkHandler),
-	})
+// runServer serves links until the process is signaled to stop
+func runServer(config *config.Config, configPath string) {
+	shutdownTracing, err := initTracing(config.Tracing)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not configure tracing")
+	}
 
-	router.PathPrefix("/").Handler(handlers.MethodHandler{
-		"GET": http.HandlerFunc(app.followLinkHandler),
-	})
+	app := newApp(config, configPath)
+
+	if config.Backup.Interval > 0 {
+		snapshotter, ok := app.storage.(storage.Snapshotter)
+		if !ok {
+			log.Fatal().Str("engine", config.Database.Engine).Msg("storage engine does not support backups")
+		}
+		go runBackups(snapshotter, config.Backup)
+	}
+
+	if config.Stale.After > 0 {
+		go app.runStalePolicy()
+	}
+
+	if config.Checker.Interval > 0 {
+		go newLinkChecker(app.storage, config.Checker).run()
+	}
+
+	router, err := app.newRouter(config.APIPrefix)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not configure routes")
+	}
+
+	var tlsConfig *tls.Config
+	if config.TLS.Enabled() {
+		certs, err := newCertReloader(config.TLS.Cert, config.TLS.Key)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not load tls certificate")
+		}
+		go certs.watch(context.Background())
+
+		tlsConfig = &tls.Config{
+			GetCertificate: certs.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+
+		if config.TLS.RedirectHTTP {
+			router.Use(httpsRedirectMiddleware(config.TLS))
+		}
+	}
 
 	server := http.Server{
-		Addr:         config.Host,
 		Handler:      router,
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
+		ConnContext:  withConnListener,
+	}
+
+	listeners, err := listen(config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not listen")
+	}
+
+	for name, listener := range listeners {
+		log.Info().Str("name", string(name)).Str("url", listener.Addr().String()).Msg("starting http service")
 	}
+	err = app.serve(&server, listeners, tlsConfig)
 
-	log.Info().Str("url", config.Host).Msg("starting http service")
-	log.Fatal().Err(server.ListenAndServe()).Msg("http service failed unexpectedly")
+	// Flush any spans recorded during shutdown before exiting
+	tracingErr := shutdownTracing(context.Background())
+	if tracingErr != nil {
+		log.Error().Err(tracingErr).Msg("could not flush traces")
+	}
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("http service failed unexpectedly")
+	}
 }
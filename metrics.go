@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// redirectOutcome represents the result of attempting to follow a short link
+type redirectOutcome string
+
+const (
+	redirectFound       redirectOutcome = "found"
+	redirectNotFound    redirectOutcome = "not_found"
+	redirectArchived    redirectOutcome = "archived"
+	redirectInactive    redirectOutcome = "inactive"
+	redirectExpired     redirectOutcome = "expired"
+	redirectTooManyHops redirectOutcome = "too_many_hops"
+	redirectError       redirectOutcome = "error"
+)
+
+// All metrics are registered with the default prometheus registry, which also
+// includes Go runtime and process statistics.
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "goto",
+		Name:      "http_requests_total",
+		Help:      "Number of http requests handled by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "goto",
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of http requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	redirectsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "goto",
+		Name:      "redirects_total",
+		Help:      "Number of attempts to follow a short link by outcome.",
+	}, []string{"outcome"})
+
+	storageOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "goto",
+		Name:      "storage_operation_duration_seconds",
+		Help:      "Latency of storage engine operations by method.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+
+	storageOperationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "goto",
+		Name:      "storage_operation_errors_total",
+		Help:      "Number of unexpected storage engine errors by method.",
+	}, []string{"operation"})
+
+	hitRecorderBacklog = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "goto",
+		Name:      "hit_recorder_backlog",
+		Help:      "Number of hit count updates waiting to be written to storage.",
+	})
+)
+
+// metricsMiddleware records request counts and latency labeled by the route template
+// that matched, so that link IDs do not end up as label values.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		route := routeTemplate(req)
+
+		captured := httpsnoop.CaptureMetrics(next, w, req)
+
+		httpRequestsTotal.WithLabelValues(route, req.Method, strconv.Itoa(captured.Code)).Inc()
+		httpRequestDuration.WithLabelValues(route, req.Method).Observe(captured.Duration.Seconds())
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/clintjedwards/goto/models"
+	"github.com/clintjedwards/goto/storage"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentStorageKeepsSnapshots(t *testing.T) {
+	engine := instrumentStorage(newTestBoltEngine(t, "goto.db"), "bolt")
+
+	if _, ok := engine.(storage.Snapshotter); !ok {
+		t.Errorf("instrumented bolt engine should still support snapshots")
+	}
+
+	before := testutil.ToFloat64(storageOperationErrors.WithLabelValues("GetLink"))
+
+	_, err := engine.GetLink(context.Background(), "missing")
+	if err == nil {
+		t.Fatalf("retrieving a missing link should fail")
+	}
+
+	after := testutil.ToFloat64(storageOperationErrors.WithLabelValues("GetLink"))
+	if after != before {
+		t.Errorf("missing links should not be counted as storage errors")
+	}
+}
+
+func TestMetricsMiddlewareUsesRouteTemplate(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/links/{id}", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	router.Use(metricsMiddleware)
+
+	before := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("/links/{id}", "GET", "418"))
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/links/github", nil))
+
+	after := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("/links/{id}", "GET", "418"))
+	if after != before+1 {
+		t.Errorf("request was not counted against its route template; before %v, after %v", before, after)
+	}
+}
+
+func TestRedirectOutcomes(t *testing.T) {
+	app := newTestApp(t)
+	router, err := app.newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	links := []models.Link{
+		{ID: "ended", URL: "https://example.com", Schedule: &models.Schedule{ActiveUntil: time.Now().Add(-time.Hour).Unix()}},
+		{ID: "launch", URL: "https://example.com", Schedule: &models.Schedule{ActiveFrom: time.Now().Add(time.Hour).Unix()}},
+		{ID: "github", URL: "https://github.com"},
+	}
+	for _, link := range links {
+		err := app.storage.CreateLink(context.Background(), &link)
+		if err != nil {
+			t.Fatalf("could not create link: %v", err)
+		}
+	}
+
+	tests := map[string]redirectOutcome{
+		"/ended":   redirectExpired,
+		"/launch":  redirectInactive,
+		"/github":  redirectFound,
+		"/missing": redirectNotFound,
+	}
+
+	for path, outcome := range tests {
+		before := testutil.ToFloat64(redirectsTotal.WithLabelValues(string(outcome)))
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+
+		after := testutil.ToFloat64(redirectsTotal.WithLabelValues(string(outcome)))
+		if after != before+1 {
+			t.Errorf("%s was not counted as %s; before %v, after %v", path, outcome, before, after)
+		}
+	}
+}
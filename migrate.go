@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clintjedwards/goto/config"
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+	"github.com/clintjedwards/goto/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// migrateResult tracks what happened to each link during a migration
+type migrateResult struct {
+	Copied  int // links written to the destination
+	Skipped int // links already present in the destination with identical contents
+
+	Rejected []string // links not copied because the url policy doesn't allow where they point
+}
+
+// runMigrate copies all links from one storage engine to another.
+//
+// Engines are described as "bolt:<path>" or "redis://[:password@]host:port[/db]".
+// The migration is safe to run repeatedly; links that were already copied
+// are skipped, so an interrupted run can simply be started again. Links the configured url
+// policy doesn't allow are left behind and reported. Hit history and visit counts aren't
+// copied, so sources with any are refused unless leaving them behind is asked for.
+func runMigrate(config *config.Config, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := flags.String("from", "", "source storage engine (ex. bolt:/tmp/go.db)")
+	to := flags.String("to", "", "destination storage engine (ex. redis://localhost:6379/0)")
+	withoutHistory := flags.Bool("without-history", false, "copy links even though their hit history and visit counts are left behind")
+	_ = flags.Parse(args)
+
+	if *from == "" || *to == "" {
+		flags.Usage()
+		return errors.New("both --from and --to must be specified")
+	}
+
+	if *from == *to {
+		return errors.New("source and destination must be different")
+	}
+
+	srcConfig, err := parseEngineSpec(*from)
+	if err != nil {
+		return fmt.Errorf("invalid source: %w", err)
+	}
+
+	dstConfig, err := parseEngineSpec(*to)
+	if err != nil {
+		return fmt.Errorf("invalid destination: %w", err)
+	}
+
+	src, err := initStorage(srcConfig)
+	if err != nil {
+		return fmt.Errorf("could not open source: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := initStorage(dstConfig)
+	if err != nil {
+		return fmt.Errorf("could not open destination: %w", err)
+	}
+	defer dst.Close()
+
+	ctx := context.Background()
+
+	history, err := linksWithHistory(ctx, src)
+	if err != nil {
+		return err
+	}
+	if len(history) > 0 {
+		if !*withoutHistory {
+			return fmt.Errorf("%d links have hit history or visit counts, which can't be migrated; "+
+				"use --without-history to copy the links without them", len(history))
+		}
+		log.Warn().Strs("ids", history).Msg("leaving hit history and visit counts behind")
+	}
+
+	policy := models.URLPolicy{
+		AllowedSchemes: config.Policy.AllowedSchemes,
+		AllowedDomains: config.Policy.AllowedDomains,
+		DeniedDomains:  config.Policy.DeniedDomains,
+	}
+
+	result, err := migrateLinks(ctx, src, dst, policy)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Int("copied", result.Copied).Int("skipped", result.Skipped).Int("rejected", len(result.Rejected)).
+		Msg("copied links")
+
+	err = verifyMigration(ctx, src, dst, result.Rejected)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("from", *from).Str("to", *to).Msg("migration complete and verified")
+	return nil
+}
+
+// parseEngineSpec turns a storage engine description into database configuration
+func parseEngineSpec(spec string) (*config.DatabaseConfig, error) {
+	switch {
+	case strings.HasPrefix(spec, string(storage.BoltEngine)+":"):
+		path := strings.TrimPrefix(spec, string(storage.BoltEngine)+":")
+		if path == "" {
+			return nil, errors.New("bolt engine requires a file path")
+		}
+
+		return &config.DatabaseConfig{
+			Engine: string(storage.BoltEngine),
+			Bolt:   &config.BoltConfig{Path: path},
+		}, nil
+
+	case strings.HasPrefix(spec, string(storage.RedisEngine)+"://"):
+		parsedURL, err := url.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		if parsedURL.Host == "" {
+			return nil, errors.New("redis engine requires a host")
+		}
+
+		redisConfig := &config.RedisConfig{Host: parsedURL.Host}
+
+		if parsedURL.User != nil {
+			password, set := parsedURL.User.Password()
+			if !set {
+				password = parsedURL.User.Username()
+			}
+			redisConfig.Password = password
+		}
+
+		dbNumber := strings.Trim(parsedURL.Path, "/")
+		if dbNumber != "" {
+			redisConfig.DB, err = strconv.Atoi(dbNumber)
+			if err != nil {
+				return nil, fmt.Errorf("invalid redis database number %q", dbNumber)
+			}
+		}
+
+		return &config.DatabaseConfig{
+			Engine: string(storage.RedisEngine),
+			Redis:  redisConfig,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unrecognized storage engine %q; expected bolt:<path> or redis://<host>", spec)
+	}
+}
+
+// migrateLinks writes every link from src into dst unchanged. Links that already
+// exist in dst are skipped if identical; any other existing link is considered a
+// conflict and stops the migration. Links that policy doesn't allow are rejected
+// rather than copied.
+func migrateLinks(ctx context.Context, src, dst storage.Engine, policy models.URLPolicy) (migrateResult, error) {
+	result := migrateResult{}
+
+	links, err := src.GetAllLinks(ctx)
+	if err != nil {
+		return result, fmt.Errorf("could not retrieve links from source: %w", err)
+	}
+
+	for _, id := range sortedLinkIDs(links) {
+		link := links[id]
+
+		err := policy.CheckLink(link)
+		if err != nil {
+			log.Warn().Err(err).Str("id", id).Msg("not copying link the url policy doesn't allow")
+			result.Rejected = append(result.Rejected, id)
+			continue
+		}
+
+		err = dst.CreateLink(ctx, &link)
+		if err == nil {
+			result.Copied++
+			continue
+		}
+
+		if !errors.Is(err, utilErrors.ErrExists) {
+			return result, fmt.Errorf("could not copy link %q: %w", id, err)
+		}
+
+		existingLink, err := dst.GetLink(ctx, id)
+		if err != nil {
+			return result, fmt.Errorf("could not retrieve link %q from destination: %w", id, err)
+		}
+
+		same, err := linksEqual(link, existingLink)
+		if err != nil {
+			return result, err
+		}
+		if !same {
+			return result, fmt.Errorf("link %q already exists in destination with different contents", id)
+		}
+
+		result.Skipped++
+	}
+
+	return result, nil
+}
+
+// linksWithHistory returns the links in src with hit history or visit counts, neither of which
+// migrations copy. Hourly hits are only kept alongside daily ones, so daily hits are enough to go by.
+func linksWithHistory(ctx context.Context, src storage.Engine) ([]string, error) {
+	links, err := src.GetAllLinks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve links from source: %w", err)
+	}
+
+	hits, err := src.GetHitTotals(ctx, models.Daily, time.Unix(0, 0), time.Now().Add(24*time.Hour))
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve hit history from source: %w", err)
+	}
+
+	history := []string{}
+	for _, id := range sortedLinkIDs(links) {
+		if hits[id] > 0 {
+			history = append(history, id)
+			continue
+		}
+
+		visits, err := src.GetVisits(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve visits to %q from source: %w", id, err)
+		}
+		for _, counts := range visits {
+			if len(counts) > 0 {
+				history = append(history, id)
+				break
+			}
+		}
+	}
+
+	return history, nil
+}
+
+// verifyMigration confirms that every link in src other than those rejected is present and
+// identical in dst
+func verifyMigration(ctx context.Context, src, dst storage.Engine, rejected []string) error {
+	srcLinks, err := src.GetAllLinks(ctx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve links from source: %w", err)
+	}
+	for _, id := range rejected {
+		delete(srcLinks, id)
+	}
+
+	dstLinks, err := dst.GetAllLinks(ctx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve links from destination: %w", err)
+	}
+
+	ids := sortedLinkIDs(srcLinks)
+
+	for _, id := range ids {
+		if _, ok := dstLinks[id]; !ok {
+			return fmt.Errorf("verification failed: link %q missing from destination", id)
+		}
+	}
+
+	srcChecksum, err := linksChecksum(srcLinks, ids)
+	if err != nil {
+		return err
+	}
+
+	dstChecksum, err := linksChecksum(dstLinks, ids)
+	if err != nil {
+		return err
+	}
+
+	if srcChecksum != dstChecksum {
+		return fmt.Errorf("verification failed: checksum mismatch; source %s, destination %s",
+			srcChecksum, dstChecksum)
+	}
+
+	if len(dstLinks) != len(srcLinks) {
+		log.Warn().Int("source", len(srcLinks)).Int("destination", len(dstLinks)).
+			Msg("destination contains links not present in source")
+	}
+
+	log.Info().Int("links", len(ids)).Str("checksum", srcChecksum).Msg("verified migration")
+	return nil
+}
+
+// linksChecksum computes a sha256 sum over the given links in the order of ids
+func linksChecksum(links map[string]models.Link, ids []string) (string, error) {
+	hash := sha256.New()
+
+	for _, id := range ids {
+		encodedLink, err := json.Marshal(links[id])
+		if err != nil {
+			return "", err
+		}
+
+		hash.Write([]byte(id))
+		hash.Write(encodedLink)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func linksEqual(a, b models.Link) (bool, error) {
+	encodedA, err := json.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+
+	encodedB, err := json.Marshal(b)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(encodedA, encodedB), nil
+}
+
+func sortedLinkIDs(links map[string]models.Link) []string {
+	ids := make([]string, 0, len(links))
+	for id := range links {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/clintjedwards/goto/config"
+	"github.com/clintjedwards/goto/models"
+	"github.com/clintjedwards/goto/storage"
+)
+
+func TestParseEngineSpec(t *testing.T) {
+	tests := map[string]struct {
+		spec        string
+		want        *config.DatabaseConfig
+		shouldError bool
+	}{
+		"bolt": {
+			spec: "bolt:/tmp/go.db",
+			want: &config.DatabaseConfig{Engine: "bolt", Bolt: &config.BoltConfig{Path: "/tmp/go.db"}},
+		},
+		"redis": {
+			spec: "redis://localhost:6379",
+			want: &config.DatabaseConfig{Engine: "redis", Redis: &config.RedisConfig{Host: "localhost:6379"}},
+		},
+		"redis with password and db": {
+			spec: "redis://:secret@localhost:6379/3",
+			want: &config.DatabaseConfig{Engine: "redis",
+				Redis: &config.RedisConfig{Host: "localhost:6379", Password: "secret", DB: 3}},
+		},
+		"bolt without path": {
+			spec:        "bolt:",
+			shouldError: true,
+		},
+		"redis with invalid db": {
+			spec:        "redis://localhost:6379/first",
+			shouldError: true,
+		},
+		"unknown engine": {
+			spec:        "postgres://localhost",
+			shouldError: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseEngineSpec(tc.spec)
+			if tc.shouldError {
+				if err == nil {
+					t.Errorf("spec %q should have failed to parse", tc.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not parse spec %q: %v", tc.spec, err)
+			}
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Errorf("parsed config mismatch; want %+v; got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func newTestBoltEngine(t *testing.T, name string) storage.Engine {
+	t.Helper()
+
+	engine, err := initStorage(&config.DatabaseConfig{
+		Engine: string(storage.BoltEngine),
+		Bolt:   &config.BoltConfig{Path: filepath.Join(t.TempDir(), name)},
+	})
+	if err != nil {
+		t.Fatalf("could not create bolt engine: %v", err)
+	}
+
+	return engine
+}
+
+func TestMigrateLinks(t *testing.T) {
+	src := newTestBoltEngine(t, "src.db")
+	dst := newTestBoltEngine(t, "dst.db")
+
+	links := []models.Link{
+		{ID: "github", URL: "https://github.com", Created: 1580000000, Hits: 42, Kind: models.Standard, Tags: []string{"code"}},
+		{ID: "repo", URL: "https://github.com/clintjedwards/{}", Created: 1580000001, Hits: 7, Kind: models.Formatted},
+	}
+	for _, link := range links {
+		link := link
+		if err := src.CreateLink(context.Background(), &link); err != nil {
+			t.Fatalf("could not seed source: %v", err)
+		}
+	}
+
+	// Simulate an interrupted previous run by copying one link ahead of time
+	if err := dst.CreateLink(context.Background(), &links[0]); err != nil {
+		t.Fatalf("could not seed destination: %v", err)
+	}
+
+	result, err := migrateLinks(context.Background(), src, dst, models.URLPolicy{})
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	if result.Copied != 1 || result.Skipped != 1 {
+		t.Errorf("unexpected migration result; want 1 copied, 1 skipped; got %+v", result)
+	}
+
+	if err := verifyMigration(context.Background(), src, dst, nil); err != nil {
+		t.Fatalf("verification failed: %v", err)
+	}
+
+	for _, want := range links {
+		got, err := dst.GetLink(context.Background(), want.ID)
+		if err != nil {
+			t.Fatalf("could not retrieve migrated link %q: %v", want.ID, err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("migrated link mismatch; want %+v; got %+v", want, got)
+		}
+	}
+
+	tagged, err := dst.GetLinksByTag(context.Background(), "code")
+	if err != nil {
+		t.Fatalf("could not retrieve migrated links by tag: %v", err)
+	}
+	if _, found := tagged["github"]; !found || len(tagged) != 1 {
+		t.Errorf("migrated links should be indexed by tag; got %v", tagged)
+	}
+
+	result, err = migrateLinks(context.Background(), src, dst, models.URLPolicy{})
+	if err != nil {
+		t.Fatalf("repeated migration failed: %v", err)
+	}
+	if result.Copied != 0 || result.Skipped != 2 {
+		t.Errorf("repeated migration should skip everything; got %+v", result)
+	}
+}
+
+func TestMigrateLinksConflict(t *testing.T) {
+	src := newTestBoltEngine(t, "src.db")
+	dst := newTestBoltEngine(t, "dst.db")
+
+	if err := src.CreateLink(context.Background(), &models.Link{ID: "github", URL: "https://github.com", Kind: models.Standard}); err != nil {
+		t.Fatalf("could not seed source: %v", err)
+	}
+	if err := dst.CreateLink(context.Background(), &models.Link{ID: "github", URL: "https://gitlab.com", Kind: models.Standard}); err != nil {
+		t.Fatalf("could not seed destination: %v", err)
+	}
+
+	_, err := migrateLinks(context.Background(), src, dst, models.URLPolicy{})
+	if err == nil {
+		t.Errorf("migration should fail when destination has a conflicting link")
+	}
+}
+
+func TestMigrateLinksPolicy(t *testing.T) {
+	src := newTestBoltEngine(t, "src.db")
+	dst := newTestBoltEngine(t, "dst.db")
+
+	links := []models.Link{
+		{ID: "github", URL: "https://github.com", Kind: models.Standard},
+		{ID: "paste", URL: "https://pastebin.com/abc", Kind: models.Standard},
+		{ID: "tool", URL: "https://tool.example.com", Kind: models.Standard, Fallbacks: []string{"javascript:alert(1)"}},
+	}
+	for _, link := range links {
+		if err := src.CreateLink(context.Background(), &link); err != nil {
+			t.Fatalf("could not seed source: %v", err)
+		}
+	}
+
+	result, err := migrateLinks(context.Background(), src, dst, models.URLPolicy{DeniedDomains: []string{"pastebin.com"}})
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	if result.Copied != 1 || !reflect.DeepEqual(result.Rejected, []string{"paste", "tool"}) {
+		t.Errorf("links breaking the policy should be rejected; got %+v", result)
+	}
+
+	if err := verifyMigration(context.Background(), src, dst, result.Rejected); err != nil {
+		t.Fatalf("verification failed: %v", err)
+	}
+
+	if _, err := dst.GetLink(context.Background(), "paste"); err == nil {
+		t.Errorf("rejected links should not be copied")
+	}
+}
+
+func TestLinksWithHistory(t *testing.T) {
+	src := newTestBoltEngine(t, "src.db")
+
+	for _, id := range []string{"followed", "visited", "unused"} {
+		if err := src.CreateLink(context.Background(), &models.Link{ID: id, URL: "https://example.com", Kind: models.Standard}); err != nil {
+			t.Fatalf("could not seed source: %v", err)
+		}
+	}
+
+	if history, err := linksWithHistory(context.Background(), src); err != nil || len(history) != 0 {
+		t.Fatalf("links never followed have no history; got %v, %v", history, err)
+	}
+
+	if err := src.BumpHitCount(context.Background(), "followed", time.Now()); err != nil {
+		t.Fatalf("could not record hit: %v", err)
+	}
+	if err := src.RecordVisit(context.Background(), "visited", models.Visit{Referrer: "example.com"}); err != nil {
+		t.Fatalf("could not record visit: %v", err)
+	}
+
+	history, err := linksWithHistory(context.Background(), src)
+	if err != nil {
+		t.Fatalf("could not check history: %v", err)
+	}
+	if !reflect.DeepEqual(history, []string{"followed", "visited"}) {
+		t.Errorf("unexpected links with history; got %v", history)
+	}
+}
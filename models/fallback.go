@@ -0,0 +1,80 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+)
+
+// MaxFallbacks is the most fallback urls a link can have
+const MaxFallbacks = 10
+
+// Failover is where the link checker decided a link with fallbacks should redirect when it last
+// checked the link's destinations. It is kept on the link so that following it needs no requests.
+type Failover struct {
+	URL      string `json:"url"`                // the first healthy destination; the link's url when it is healthy or nothing is
+	Fallback *int   `json:"fallback,omitempty"` // index of url in the link's fallbacks, if it is one
+	Healthy  bool   `json:"healthy"`            // false when every destination failed its check
+	Decided  int64  `json:"decided"`            // epoch time of the check that made the decision
+}
+
+// FailoverURL returns the url the link redirects to according to the latest checks of its
+// destinations. That is the link's own url unless it was down and a fallback wasn't.
+func (l Link) FailoverURL() string {
+	if l.Failover == nil || l.Failover.Fallback == nil {
+		return l.URL
+	}
+
+	// The decision may have been made before the fallbacks last changed
+	fallback := *l.Failover.Fallback
+	if fallback < 0 || fallback >= len(l.Fallbacks) || l.Fallbacks[fallback] != l.Failover.URL {
+		return l.URL
+	}
+
+	return l.Failover.URL
+}
+
+// checkFallbacks checks that fallbacks are complete urls allowed by policy, just like a link's own
+// url. Fallbacks are only used once the link checker finds url down, so formatted links, which it
+// can't check, can't have any. Neither can routed links, which mostly send visits elsewhere.
+func checkFallbacks(url string, routed bool, policy URLPolicy) validation.RuleFunc {
+	return func(value interface{}) error {
+		fallbacks, _ := value.([]string)
+
+		if len(fallbacks) > 0 && isFormattedLink(url) {
+			return fmt.Errorf("formatted links can't have fallbacks since their url can't be checked")
+		}
+
+		if len(fallbacks) > 0 && routed {
+			return fmt.Errorf("links with destinations can't have fallbacks since only their url is checked")
+		}
+
+		if len(fallbacks) > MaxFallbacks {
+			return fmt.Errorf("links can have at most %d fallbacks", MaxFallbacks)
+		}
+
+		for i, fallback := range fallbacks {
+			if isFormattedLink(fallback) {
+				return fmt.Errorf("fallback %d: fallbacks can't be formatted since they are checked as they are", i)
+			}
+
+			if slices.Contains(fallbacks[:i], fallback) {
+				return fmt.Errorf("fallback %d: fallbacks must not repeat", i)
+			}
+
+			err := policy.Check(fallback)
+			if err != nil {
+				return fmt.Errorf("fallback %d: %w", i, err)
+			}
+
+			err = validation.Validate(fallback, validation.Required, is.URL)
+			if err != nil {
+				return fmt.Errorf("fallback %d: %w", i, err)
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestFailoverURL(t *testing.T) {
+	fallback := 1
+	link := Link{
+		URL:       "https://tool.example.com",
+		Fallbacks: []string{"https://tool-dr.example.com", "https://status.example.com"},
+		Failover:  &Failover{URL: "https://status.example.com", Fallback: &fallback, Healthy: true},
+	}
+
+	if got := link.FailoverURL(); got != "https://status.example.com" {
+		t.Errorf("link should redirect to the chosen fallback; got %s", got)
+	}
+
+	link.Fallbacks = []string{"https://tool-dr.example.com"}
+	if got := link.FailoverURL(); got != link.URL {
+		t.Errorf("decisions about fallbacks that were removed should be ignored; got %s", got)
+	}
+
+	link.Failover = nil
+	if got := link.FailoverURL(); got != link.URL {
+		t.Errorf("links that haven't been checked should redirect to their url; got %s", got)
+	}
+}
+
+func TestCheckFallbacks(t *testing.T) {
+	tests := map[string]struct {
+		url       string
+		routed    bool
+		fallbacks []string
+		valid     bool
+	}{
+		"fallbacks":      {"https://tool.example.com", false, []string{"https://status.example.com"}, true},
+		"formatted link": {"https://tool.example.com/{}", false, []string{"https://status.example.com"}, false},
+		"routed link":    {"https://tool.example.com", true, []string{"https://status.example.com"}, false},
+		"routed":         {"https://tool.example.com", true, nil, true},
+		"formatted":      {"https://tool.example.com", false, []string{"https://status.example.com/{}"}, false},
+		"repeated":       {"https://tool.example.com", false, []string{"https://status.example.com", "https://status.example.com"}, false},
+		"denied":         {"https://tool.example.com", false, []string{"https://pastebin.com/status"}, false},
+		"invalid":        {"https://tool.example.com", false, []string{"status page"}, false},
+	}
+
+	policy := URLPolicy{DeniedDomains: []string{"pastebin.com"}}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := checkFallbacks(tc.url, tc.routed, policy)(tc.fallbacks)
+			if (err == nil) != tc.valid {
+				t.Errorf("unexpected result; want valid %v; got %v", tc.valid, err)
+			}
+		})
+	}
+}
@@ -2,11 +2,13 @@ package models
 
 import (
 	"errors"
-	"net/url"
+	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
+	utilErrors "github.com/clintjedwards/goto/errors"
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
 )
@@ -27,80 +29,289 @@ const (
 	// and get back this URL: github.com/clintjedwards/repos/test/issues
 	// This enables a user to subtitute variables within the middle of a potentially complex URL.
 	Formatted Kind = "formatted"
+
+	// Routed links send each visit to one of several destinations, picked by conditions on the
+	// visit or at random by weight. For example, 10% of visits could go to a canary dashboard.
+	// The link's own URL is used when no destination applies.
+	Routed Kind = "routed"
 )
 
 // CreateLinkRequest is a representation of the user input from a newly created link.
 type CreateLinkRequest struct {
-	ID  string `json:"id"`
-	URL string `json:"url"`
+	ID           string            `json:"id,omitempty"` // a short name is generated when empty
+	URL          string            `json:"url"`
+	Description  string            `json:"description,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Schedule     *Schedule         `json:"schedule,omitempty"`
+	Destinations []Destination     `json:"destinations,omitempty"`
+	Fallbacks    []string          `json:"fallbacks,omitempty"`
 }
 
 // Link is a representation of a shortened URL
 type Link struct {
-	ID      string `json:"id"` // the short name of a link
-	URL     string `json:"url"`
-	Created int64  `json:"created"` // epoch time
-	Hits    int64  `json:"hits"`    // number of visits to link
-	Kind    Kind   `json:"kind"`
+	ID           string `json:"id"` // the short name of a link
+	URL          string `json:"url"`
+	Created      int64  `json:"created"` // epoch time
+	Hits         int64  `json:"hits"`    // number of visits to link
+	Kind         Kind   `json:"kind"`
+	LastAccessed int64  `json:"last_accessed"`          // epoch time of the latest visit; 0 if never visited
+	Archived     int64  `json:"archived,omitempty"`     // epoch time the link was archived; archived links don't redirect
+	LastChecked  int64  `json:"last_checked,omitempty"` // epoch time the destination was last checked by the link checker
+	LastStatus   int    `json:"last_status,omitempty"`  // http status the destination returned when last checked
+	CheckError   string `json:"check_error,omitempty"`  // why the destination couldn't be reached when last checked
+
+	Description string            `json:"description,omitempty"` // what the link is for
+	Tags        []string          `json:"tags,omitempty"`        // lowercase and sorted; links can be listed by tag
+	Labels      map[string]string `json:"labels,omitempty"`      // arbitrary metadata, ex. owner: sre
+	Schedule    *Schedule         `json:"schedule,omitempty"`    // when the link redirects and where to at certain times
+
+	Destinations []Destination `json:"destinations,omitempty"` // where routed links send visitors
+
+	Fallbacks []string  `json:"fallbacks,omitempty"` // used in order in place of url while the link checker finds it down
+	Failover  *Failover `json:"failover,omitempty"`  // where the link checker last decided the link should redirect
+}
+
+// TagCount is the number of links with a tag
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Links int64  `json:"links"`
+}
+
+// HasTag reports whether the link is tagged with tag
+func (l Link) HasTag(tag string) bool {
+	for _, linkTag := range l.Tags {
+		if linkTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Broken reports whether the destination failed the latest check. Links that haven't been
+// checked aren't broken.
+func (l Link) Broken() bool {
+	return l.CheckError != "" || l.LastStatus >= 400
+}
+
+// LastUsed returns the epoch time the link was last visited, or created if it never has been
+func (l Link) LastUsed() int64 {
+	if l.LastAccessed == 0 {
+		return l.Created
+	}
+	return l.LastAccessed
 }
 
 func (l CreateLinkRequest) ToLink() *Link {
-	kind := Standard
-	if isFormattedLink(l.URL) {
-		kind = Formatted
+	link := &Link{
+		ID:          l.ID,
+		URL:         l.URL,
+		Created:     time.Now().Unix(),
+		Hits:        0,
+		Kind:        KindOf(l.URL),
+		Description: strings.TrimSpace(l.Description),
+		Tags:        NormalizeTags(l.Tags),
+		Labels:      l.Labels,
+		Schedule:    l.Schedule,
+		Fallbacks:   l.Fallbacks,
 	}
 
-	return &Link{
-		ID:      l.ID,
-		URL:     l.URL,
-		Created: time.Now().Unix(),
-		Hits:    0,
-		Kind:    kind,
+	if len(l.Destinations) > 0 {
+		link.Kind = Routed
+		link.Destinations = make([]Destination, len(l.Destinations))
+		for i, destination := range l.Destinations {
+			destination.Hits = 0
+			link.Destinations[i] = destination
+		}
 	}
+
+	return link
+}
+
+// KindOf returns the kind of link that redirects to url
+func KindOf(url string) Kind {
+	if isFormattedLink(url) {
+		return Formatted
+	}
+	return Standard
+}
+
+// NormalizeTags lowercases tags, removing duplicates and sorting them. Nil is returned for no tags
+// so that untagged links leave the field out.
+func NormalizeTags(tags []string) []string {
+	seen := map[string]struct{}{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if _, found := seen[tag]; found || tag == "" {
+			continue
+		}
+		seen[tag] = struct{}{}
+		normalized = append(normalized, tag)
+	}
+
+	if len(normalized) == 0 {
+		return nil
+	}
+
+	sort.Strings(normalized)
+	return normalized
 }
 
 func isFormattedLink(url string) bool {
 	return strings.Contains(url, "{}")
 }
 
-// Validate checks URL and ID to make sure they are valid and conform to standards
-func (l CreateLinkRequest) Validate(maxlength int, serverHost string) error {
+// Validate checks URL and ID to make sure they are valid and conform to standards.
+// reservedIDs lists short names that are unavailable, such as those taken by the app's routes,
+// and policy restricts where the URL may point. Links that lead back to themselves through
+// the server are checked separately since that depends on other links.
+func (l CreateLinkRequest) Validate(maxlength int, reservedIDs []string, policy URLPolicy) error {
+	// Tags are checked as they will be stored, so that padding, case and duplicates that
+	// normalizing removes don't fail the request
+	l.Tags = NormalizeTags(l.Tags)
+
 	err := validation.ValidateStruct(&l,
-		// URL must not be empty and a valid URL
-		validation.Field(&l.URL, validation.Required, is.URL),
+		// URL must not be empty, allowed by policy and a valid URL. Policy is checked first so
+		// that disallowed schemes such as javascript: are reported as such.
+		validation.Field(&l.URL, validation.Required, validation.By(checkPolicy(policy)), is.URL),
 		// ID cannot be empty, the length must be below configured max, and must be in correct format
 		validation.Field(&l.ID,
-			validation.Required, validation.Length(1, maxlength), validation.By(checkValidID)),
+			validation.Required, validation.Length(1, maxlength), validation.By(checkValidID),
+			validation.By(checkUnreservedID(reservedIDs))),
+		validation.Field(&l.Description, validation.Length(0, MaxDescriptionLength)),
+		validation.Field(&l.Tags, validation.Length(0, MaxTags), validation.Each(validation.By(checkTag))),
+		validation.Field(&l.Labels, validation.Length(0, MaxLabels), validation.By(checkLabels)),
+		validation.Field(&l.Schedule, validation.By(checkSchedule(policy, len(l.Destinations) > 0))),
+		validation.Field(&l.Destinations, validation.By(checkDestinations(policy))),
+		validation.Field(&l.Fallbacks, validation.By(checkFallbacks(l.URL, len(l.Destinations) > 0, policy))),
 	)
 	if err != nil {
+		return validationError(err)
+	}
+
+	return nil
+}
+
+const (
+	// MaxDescriptionLength is the most characters a link's description can have
+	MaxDescriptionLength = 500
+	// MaxTags is the most tags a link can have
+	MaxTags = 20
+	// MaxLabels is the most labels a link can have
+	MaxLabels = 20
+	// maxTagLength is the most characters a tag or label name can have
+	maxTagLength = 64
+	// maxLabelValueLength is the most characters a label value can have
+	maxLabelValueLength = 256
+)
+
+// tagRegEx matches valid tags and label names
+var tagRegEx = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// errReservedID is returned by checkUnreservedID so that it can be told apart from other invalid IDs
+var errReservedID = errors.New("requested id is reserved and cannot be used")
+
+// fieldCodes maps the json name of each validated field to the code reported when it is invalid
+var fieldCodes = map[string]utilErrors.Code{
+	"id":           utilErrors.CodeInvalidID,
+	"url":          utilErrors.CodeInvalidURL,
+	"description":  utilErrors.CodeInvalidRequest,
+	"tags":         utilErrors.CodeInvalidRequest,
+	"labels":       utilErrors.CodeInvalidRequest,
+	"schedule":     utilErrors.CodeInvalidRequest,
+	"destinations": utilErrors.CodeInvalidRequest,
+	"fallbacks":    utilErrors.CodeInvalidRequest,
+}
+
+// validationError converts validation failures into an api error with a detail for each field.
+// The error takes the code of the first invalid field so that the common case of a single
+// problem can be handled by looking at the code alone.
+func validationError(err error) error {
+	fieldErrs, ok := err.(validation.Errors)
+	if !ok {
 		return err
 	}
 
-	url, _ := url.Parse(l.URL)
-	if serverHost == url.Host {
-		return errors.New("redirect loop detected")
+	fields := []string{}
+	for field := range fieldErrs {
+		fields = append(fields, field)
 	}
+	sort.Strings(fields)
 
-	return nil
+	details := []utilErrors.FieldError{}
+	for _, field := range fields {
+		code := fieldCodes[field]
+		var violation *PolicyViolation
+		if errors.Is(fieldErrs[field], errReservedID) {
+			code = utilErrors.CodeReservedID
+		} else if errors.As(fieldErrs[field], &violation) {
+			code = utilErrors.CodeURLNotAllowed
+		}
+
+		details = append(details, utilErrors.FieldError{
+			Field:   field,
+			Code:    code,
+			Message: fieldErrs[field].Error(),
+		})
+	}
+
+	return utilErrors.New(details[0].Code, "link is invalid", details...)
 }
 
 // checkValidID checks for a valid short name
 // an ID can only comprise of AlphaNumeric characters and + or _
 func checkValidID(value interface{}) error {
-
-	reservedIDs := []string{"links", "create", "version", "status", "health", "edit", "api"}
-
 	s, _ := value.(string)
 	idRegEx := regexp.MustCompile("^[a-zA-Z0-9_-]+$")
 	if !idRegEx.MatchString(s) {
 		return errors.New("id is restricted to alphanumeric characters, dashes, and underscores only")
 	}
 
-	for _, id := range reservedIDs {
-		if s == id {
-			return errors.New("requested id is reserved and cannot be used")
-		}
+	return nil
+}
+
+// checkTag checks that a tag is short and made of letters, digits, dashes, dots and underscores
+func checkTag(value interface{}) error {
+	s, _ := value.(string)
+	if len(s) > maxTagLength || !tagRegEx.MatchString(s) {
+		return fmt.Errorf("tags must be at most %d letters, digits, dashes, dots or underscores", maxTagLength)
 	}
+	return nil
+}
 
+// checkLabels checks that label names are valid tags and values aren't too long
+func checkLabels(value interface{}) error {
+	labels, _ := value.(map[string]string)
+	for name, labelValue := range labels {
+		if checkTag(name) != nil {
+			return fmt.Errorf("label name %q must be at most %d letters, digits, dashes, dots or underscores", name, maxTagLength)
+		}
+		if len(labelValue) > maxLabelValueLength {
+			return fmt.Errorf("label %q must be at most %d characters", name, maxLabelValueLength)
+		}
+	}
 	return nil
 }
+
+// checkPolicy rejects urls that policy doesn't allow
+func checkPolicy(policy URLPolicy) validation.RuleFunc {
+	return func(value interface{}) error {
+		s, _ := value.(string)
+		return policy.Check(s)
+	}
+}
+
+// checkUnreservedID rejects short names that are reserved
+func checkUnreservedID(reservedIDs []string) validation.RuleFunc {
+	return func(value interface{}) error {
+		s, _ := value.(string)
+		for _, id := range reservedIDs {
+			if s == id {
+				return errReservedID
+			}
+		}
+
+		return nil
+	}
+}
@@ -1,6 +1,12 @@
 package models
 
-import "testing"
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	utilErrors "github.com/clintjedwards/goto/errors"
+)
 
 func TestIsFormattedLink(t *testing.T) {
 	tests := map[string]struct {
@@ -53,3 +59,140 @@ func TestCheckValidID(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateCodes(t *testing.T) {
+	tests := map[string]struct {
+		request CreateLinkRequest
+		code    utilErrors.Code
+		fields  int
+	}{
+		"valid": {
+			request: CreateLinkRequest{ID: "github", URL: "https://github.com"},
+		},
+		"invalid id": {
+			request: CreateLinkRequest{ID: "git hub", URL: "https://github.com"},
+			code:    utilErrors.CodeInvalidID,
+			fields:  1,
+		},
+		"reserved id": {
+			request: CreateLinkRequest{ID: "links", URL: "https://github.com"},
+			code:    utilErrors.CodeReservedID,
+			fields:  1,
+		},
+		"invalid id and url": {
+			request: CreateLinkRequest{ID: "", URL: "not a url"},
+			code:    utilErrors.CodeInvalidID,
+			fields:  2,
+		},
+		"disallowed scheme": {
+			request: CreateLinkRequest{ID: "xss", URL: "javascript:alert(1)"},
+			code:    utilErrors.CodeURLNotAllowed,
+			fields:  1,
+		},
+		"denied domain": {
+			request: CreateLinkRequest{ID: "paste", URL: "https://www.pastebin.com/abc"},
+			code:    utilErrors.CodeURLNotAllowed,
+			fields:  1,
+		},
+		"metadata": {
+			request: CreateLinkRequest{ID: "pager", URL: "https://pager.example.com",
+				Tags: []string{"OnCall", "sre"}, Labels: map[string]string{"owner": "sre-team"}},
+		},
+		"tags valid once normalized": {
+			request: CreateLinkRequest{ID: "pager", URL: "https://pager.example.com",
+				Tags: append(strings.Split("a b c d e f g h i j k l m n o p q r s", " "), " OnCall ", "A", "B")},
+		},
+		"routed with schedule rules": {
+			request: CreateLinkRequest{ID: "dash", URL: "https://dash.example.com",
+				Destinations: []Destination{{URL: "https://m.dash.example.com"}},
+				Schedule:     &Schedule{Rules: []ScheduleRule{{Days: []string{"sat"}, URL: "https://weekend.example.com"}}}},
+			code:   utilErrors.CodeInvalidRequest,
+			fields: 1,
+		},
+		"routed with fallbacks": {
+			request: CreateLinkRequest{ID: "dash", URL: "https://dash.example.com",
+				Destinations: []Destination{{URL: "https://m.dash.example.com"}},
+				Fallbacks:    []string{"https://status.example.com/dash"}},
+			code:   utilErrors.CodeInvalidRequest,
+			fields: 1,
+		},
+		"invalid tag": {
+			request: CreateLinkRequest{ID: "pager", URL: "https://pager.example.com", Tags: []string{"on call"}},
+			code:    utilErrors.CodeInvalidRequest,
+			fields:  1,
+		},
+		"invalid label name": {
+			request: CreateLinkRequest{ID: "pager", URL: "https://pager.example.com", Labels: map[string]string{"": "sre"}},
+			code:    utilErrors.CodeInvalidRequest,
+			fields:  1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.request.Validate(50, []string{"links"}, URLPolicy{DeniedDomains: []string{"pastebin.com"}})
+			if tc.code == "" {
+				if err != nil {
+					t.Errorf("request should be valid; got %v", err)
+				}
+				return
+			}
+
+			apiErr := utilErrors.ToAPIError(err)
+			if apiErr.Code != tc.code {
+				t.Errorf("unexpected error code; want %q; got %q", tc.code, apiErr.Code)
+			}
+			if len(apiErr.Details) != tc.fields {
+				t.Errorf("unexpected number of field errors; want %d; got %v", tc.fields, apiErr.Details)
+			}
+		})
+	}
+}
+
+func TestURLPolicy(t *testing.T) {
+	policy := URLPolicy{
+		AllowedSchemes: []string{"https"},
+		AllowedDomains: []string{"example.com", "github.com"},
+		DeniedDomains:  []string{"gist.github.com"},
+	}
+
+	tests := map[string]struct {
+		url  string
+		rule string
+	}{
+		"allowed domain":        {url: "https://example.com/wiki"},
+		"allowed subdomain":     {url: "https://wiki.Example.com/page"},
+		"disallowed scheme":     {url: "http://example.com", rule: "allowed_schemes"},
+		"file scheme":           {url: "file:///etc/passwd", rule: "allowed_schemes"},
+		"denied subdomain":      {url: "https://gist.github.com/someone", rule: "denied_domains"},
+		"other domain":          {url: "https://pastebin.com/abc", rule: "allowed_domains"},
+		"lookalike domain":      {url: "https://notexample.com", rule: "allowed_domains"},
+		"domain in path is not": {url: "https://evil.com/example.com", rule: "allowed_domains"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := policy.Check(tc.url)
+
+			rule := ""
+			if violation, ok := err.(*PolicyViolation); ok {
+				rule = violation.Rule
+			}
+			if rule != tc.rule {
+				t.Errorf("unexpected rule for %q; want %q; got %q (%v)", tc.url, tc.rule, rule, err)
+			}
+		})
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got := NormalizeTags([]string{"SRE", " oncall", "sre", ""})
+	want := []string{"oncall", "sre"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected tags; want %v; got %v", want, got)
+	}
+
+	if got := NormalizeTags([]string{" "}); got != nil {
+		t.Errorf("no tags should normalize to nil; got %v", got)
+	}
+}
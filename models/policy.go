@@ -0,0 +1,118 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DefaultAllowedSchemes are the schemes links may use when a policy doesn't list any
+var DefaultAllowedSchemes = []string{"http", "https"}
+
+// URLPolicy restricts where links may point. Domains match themselves and all of their
+// subdomains, and a denied domain wins over an allowed one.
+type URLPolicy struct {
+	AllowedSchemes []string `json:"allowed_schemes"`
+	AllowedDomains []string `json:"allowed_domains"` // when empty, any domain not denied is allowed
+	DeniedDomains  []string `json:"denied_domains"`
+}
+
+// PolicyViolation explains why a url isn't allowed by a policy
+type PolicyViolation struct {
+	Rule    string // the setting that rejected the url, ex. denied_domains
+	Message string
+}
+
+func (v *PolicyViolation) Error() string {
+	return v.Message
+}
+
+// Check returns a *PolicyViolation if rawURL is not allowed by the policy. URLs that can't be
+// parsed are left to other validation.
+func (p URLPolicy) Check(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+
+	schemes := p.AllowedSchemes
+	if len(schemes) == 0 {
+		schemes = DefaultAllowedSchemes
+	}
+
+	scheme := strings.ToLower(parsed.Scheme)
+	if !containsFold(schemes, scheme) {
+		return &PolicyViolation{
+			Rule:    "allowed_schemes",
+			Message: fmt.Sprintf("scheme %q is not allowed; allowed schemes are %s", scheme, strings.Join(schemes, ", ")),
+		}
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+
+	if domain, found := matchDomain(p.DeniedDomains, host); found {
+		return &PolicyViolation{
+			Rule:    "denied_domains",
+			Message: fmt.Sprintf("links to %s are not allowed", domain),
+		}
+	}
+
+	if len(p.AllowedDomains) > 0 {
+		if _, found := matchDomain(p.AllowedDomains, host); !found {
+			return &PolicyViolation{
+				Rule:    "allowed_domains",
+				Message: fmt.Sprintf("host %q is not allowed; links must point at %s", host, strings.Join(p.AllowedDomains, ", ")),
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckLink returns a *PolicyViolation if any url an existing link can redirect to is not allowed
+// by the policy, naming which of them it was
+func (p URLPolicy) CheckLink(link Link) error {
+	names, urls := []string{"url"}, []string{link.URL}
+	if link.Schedule != nil {
+		for i, rule := range link.Schedule.Rules {
+			names, urls = append(names, fmt.Sprintf("schedule rule %d", i)), append(urls, rule.URL)
+		}
+	}
+	for i, destination := range link.Destinations {
+		names, urls = append(names, fmt.Sprintf("destination %d", i)), append(urls, destination.URL)
+	}
+	for i, fallback := range link.Fallbacks {
+		names, urls = append(names, fmt.Sprintf("fallback %d", i)), append(urls, fallback)
+	}
+
+	for i, destination := range urls {
+		var violation *PolicyViolation
+		if errors.As(p.Check(destination), &violation) {
+			return &PolicyViolation{Rule: violation.Rule, Message: names[i] + ": " + violation.Message}
+		}
+	}
+
+	return nil
+}
+
+// matchDomain returns the first of domains that host is or is a subdomain of
+func matchDomain(domains []string, host string) (string, bool) {
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "*."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return domain, true
+		}
+	}
+
+	return "", false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"slices"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+)
+
+const (
+	// MaxDestinations is the most destinations a routed link can have
+	MaxDestinations = 20
+	// MaxWeight is the largest weight a destination can have
+	MaxWeight = 10000
+)
+
+// Condition types say which part of a visit a destination's condition looks at
+const (
+	ConditionUserAgent = "user_agent" // the visitor's browser, matched case-insensitively by substring
+	ConditionHeader    = "header"     // a request header, matched case-insensitively
+	ConditionQuery     = "query"      // a query parameter, matched exactly
+	ConditionGroup     = "group"      // the groups an authenticating proxy says the visitor is in
+)
+
+// ConditionTypes are all the kinds of conditions destinations can have
+var ConditionTypes = []string{ConditionUserAgent, ConditionHeader, ConditionQuery, ConditionGroup}
+
+// Destination is one of the places a routed link sends visitors. Destinations with a condition
+// are tried in order and the first one matching the visit is used. Otherwise one of the
+// destinations without a condition is picked at random in proportion to its weight.
+type Destination struct {
+	URL       string     `json:"url"`
+	Weight    int        `json:"weight,omitempty"` // share of unconditional visits relative to the other destinations; 1 when empty
+	Condition *Condition `json:"condition,omitempty"`
+	Hits      int64      `json:"hits"` // number of visits sent to this destination
+}
+
+// Condition limits a destination to visits with something in common, ex. a mobile user agent
+type Condition struct {
+	Type  string `json:"type"`            // one of ConditionTypes
+	Name  string `json:"name,omitempty"`  // the header or query parameter looked at
+	Value string `json:"value,omitempty"` // what to look for; headers and query parameters only need to be present when empty
+}
+
+// Visitor is what destination conditions know about a visit
+type Visitor struct {
+	UserAgent string
+	Header    map[string][]string // keys in canonical form, as in http.Header
+	Query     map[string][]string
+	Groups    []string
+}
+
+// Matches reports whether visitor meets the condition
+func (c *Condition) Matches(visitor Visitor) bool {
+	switch c.Type {
+	case ConditionUserAgent:
+		return strings.Contains(strings.ToLower(visitor.UserAgent), strings.ToLower(c.Value))
+	case ConditionHeader:
+		values, found := visitor.Header[textproto.CanonicalMIMEHeaderKey(c.Name)]
+		return found && (c.Value == "" || slices.ContainsFunc(values, func(value string) bool {
+			return strings.EqualFold(value, c.Value)
+		}))
+	case ConditionQuery:
+		values, found := visitor.Query[c.Name]
+		return found && (c.Value == "" || slices.Contains(values, c.Value))
+	case ConditionGroup:
+		return slices.ContainsFunc(visitor.Groups, func(group string) bool {
+			return strings.EqualFold(group, c.Value)
+		})
+	}
+	return false
+}
+
+// Route returns the index of the destination visitor is sent to, or -1 when none apply and the
+// link's own url should be used. random must return a number in [0, n).
+func (l Link) Route(visitor Visitor, random func(n int) int) int {
+	total := 0
+	for i, destination := range l.Destinations {
+		if destination.Condition != nil {
+			if destination.Condition.Matches(visitor) {
+				return i
+			}
+			continue
+		}
+		total += destination.weight()
+	}
+
+	if total == 0 {
+		return -1
+	}
+
+	roll := random(total)
+	for i, destination := range l.Destinations {
+		if destination.Condition != nil {
+			continue
+		}
+		roll -= destination.weight()
+		if roll < 0 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (d Destination) weight() int {
+	if d.Weight == 0 {
+		return 1
+	}
+	return d.Weight
+}
+
+// checkDestinations checks that a routed link's destinations are usable. Destination urls must be
+// allowed by policy just like a link's own url.
+func checkDestinations(policy URLPolicy) validation.RuleFunc {
+	return func(value interface{}) error {
+		destinations, _ := value.([]Destination)
+
+		if len(destinations) > MaxDestinations {
+			return fmt.Errorf("links can have at most %d destinations", MaxDestinations)
+		}
+
+		for i, destination := range destinations {
+			if destination.Weight < 0 || destination.Weight > MaxWeight {
+				return fmt.Errorf("destination %d: weight must be between 0 and %d", i, MaxWeight)
+			}
+
+			err := checkCondition(destination.Condition)
+			if err != nil {
+				return fmt.Errorf("destination %d: %w", i, err)
+			}
+
+			err = policy.Check(destination.URL)
+			if err != nil {
+				return fmt.Errorf("destination %d: %w", i, err)
+			}
+
+			err = validation.Validate(destination.URL, validation.Required, is.URL)
+			if err != nil {
+				return fmt.Errorf("destination %d: %w", i, err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// checkCondition checks that a condition has what its type needs to be matched
+func checkCondition(condition *Condition) error {
+	if condition == nil {
+		return nil
+	}
+
+	switch condition.Type {
+	case ConditionHeader, ConditionQuery:
+		if condition.Name == "" {
+			return fmt.Errorf("%s conditions need a name", condition.Type)
+		}
+	case ConditionUserAgent, ConditionGroup:
+		if condition.Value == "" {
+			return fmt.Errorf("%s conditions need a value", condition.Type)
+		}
+		if condition.Name != "" {
+			return fmt.Errorf("%s conditions don't take a name", condition.Type)
+		}
+	default:
+		return errors.New("condition type must be one of " + strings.Join(ConditionTypes, ", "))
+	}
+
+	return nil
+}
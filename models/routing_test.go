@@ -0,0 +1,76 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestRoute(t *testing.T) {
+	link := Link{Destinations: []Destination{
+		{URL: "https://m.example.com", Condition: &Condition{Type: ConditionUserAgent, Value: "mobile"}},
+		{URL: "https://beta.example.com", Condition: &Condition{Type: ConditionHeader, Name: "x-beta"}},
+		{URL: "https://example.com/debug", Condition: &Condition{Type: ConditionQuery, Name: "debug", Value: "1"}},
+		{URL: "https://admin.example.com", Condition: &Condition{Type: ConditionGroup, Value: "admins"}},
+		{URL: "https://canary.example.com", Weight: 10},
+		{URL: "https://stable.example.com", Weight: 90},
+	}}
+
+	tests := map[string]struct {
+		visitor Visitor
+		roll    int
+		want    int
+	}{
+		"mobile":              {Visitor{UserAgent: "Mozilla/5.0 (iPhone) Mobile/15E148"}, 0, 0},
+		"header present":      {Visitor{Header: map[string][]string{"X-Beta": {"yes"}}}, 0, 1},
+		"query matches":       {Visitor{Query: map[string][]string{"debug": {"1"}}}, 0, 2},
+		"query differs":       {Visitor{Query: map[string][]string{"debug": {"0"}}}, 50, 5},
+		"group":               {Visitor{Groups: []string{"sre", "Admins"}}, 0, 3},
+		"first of weights":    {Visitor{}, 9, 4},
+		"second of weights":   {Visitor{}, 10, 5},
+		"conditions go first": {Visitor{UserAgent: "Mobile", Groups: []string{"admins"}}, 0, 0},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := link.Route(tc.visitor, func(n int) int {
+				if n != 100 {
+					t.Errorf("weights should add up to 100; got %d", n)
+				}
+				return tc.roll
+			})
+			if got != tc.want {
+				t.Errorf("unexpected destination; want %d; got %d", tc.want, got)
+			}
+		})
+	}
+
+	conditional := Link{Destinations: link.Destinations[:4]}
+	if got := conditional.Route(Visitor{}, nil); got != -1 {
+		t.Errorf("links should fall back to their url when no destination applies; got %d", got)
+	}
+}
+
+func TestCheckDestinations(t *testing.T) {
+	tests := map[string]struct {
+		destination Destination
+		valid       bool
+	}{
+		"weighted":            {Destination{URL: "https://example.com", Weight: 10}, true},
+		"header":              {Destination{URL: "https://example.com", Condition: &Condition{Type: ConditionHeader, Name: "X-Beta"}}, true},
+		"negative weight":     {Destination{URL: "https://example.com", Weight: -1}, false},
+		"unknown type":        {Destination{URL: "https://example.com", Condition: &Condition{Type: "cookie", Name: "beta"}}, false},
+		"query without name":  {Destination{URL: "https://example.com", Condition: &Condition{Type: ConditionQuery}}, false},
+		"group without value": {Destination{URL: "https://example.com", Condition: &Condition{Type: ConditionGroup}}, false},
+		"denied url":          {Destination{URL: "https://pastebin.com/abc"}, false},
+		"invalid url":         {Destination{URL: "not a url"}, false},
+	}
+
+	policy := URLPolicy{DeniedDomains: []string{"pastebin.com"}}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := checkDestinations(policy)([]Destination{tc.destination})
+			if (err == nil) != tc.valid {
+				t.Errorf("unexpected result; want valid %v; got %v", tc.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+)
+
+// MaxScheduleRules is the most destination rules a link's schedule can have
+const MaxScheduleRules = 20
+
+// Weekdays are the names used for days in schedule rules, in time.Weekday order
+var Weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Schedule controls when a link redirects and lets it redirect somewhere else at certain times.
+// Times of day are in the schedule's timezone, or the server's configured one when empty.
+type Schedule struct {
+	ActiveFrom  int64          `json:"active_from,omitempty"`  // epoch time the link starts redirecting
+	ActiveUntil int64          `json:"active_until,omitempty"` // epoch time the link stops redirecting
+	Timezone    string         `json:"timezone,omitempty"`     // IANA name, ex. Europe/London
+	Rules       []ScheduleRule `json:"rules,omitempty"`        // the first matching rule picks the destination
+}
+
+// ScheduleRule sends a link to a different destination on certain days between certain times.
+// A rule whose end is before its start runs overnight, ex. 22:00 to 06:00.
+type ScheduleRule struct {
+	Days  []string `json:"days,omitempty"`  // any of Weekdays; every day when empty
+	Start string   `json:"start,omitempty"` // time of day the rule starts, ex. 09:00; midnight when empty
+	End   string   `json:"end,omitempty"`   // time of day the rule ends, exclusive; midnight when empty
+	URL   string   `json:"url"`
+}
+
+// Active reports whether a link with the schedule redirects at the given time
+func (s *Schedule) Active(at time.Time) bool {
+	if s == nil {
+		return true
+	}
+	if s.ActiveFrom != 0 && at.Unix() < s.ActiveFrom {
+		return false
+	}
+	if s.ActiveUntil != 0 && at.Unix() >= s.ActiveUntil {
+		return false
+	}
+	return true
+}
+
+// Expired reports whether a link with the schedule has stopped redirecting for good by the given time
+func (s *Schedule) Expired(at time.Time) bool {
+	return s != nil && s.ActiveUntil != 0 && at.Unix() >= s.ActiveUntil
+}
+
+// Match returns the index of the first rule covering the given time in location, or -1 when none do
+func (s *Schedule) Match(at time.Time, location *time.Location) int {
+	if s == nil {
+		return -1
+	}
+
+	local := at.In(location)
+	day := Weekdays[local.Weekday()]
+	minute := local.Hour()*60 + local.Minute()
+
+	for i, rule := range s.Rules {
+		if len(rule.Days) > 0 && !slices.Contains(rule.Days, day) {
+			continue
+		}
+
+		start, _ := parseTimeOfDay(rule.Start)
+		end, _ := parseTimeOfDay(rule.End)
+		if end == 0 {
+			end = 24 * 60
+		}
+
+		if start <= end && minute >= start && minute < end {
+			return i
+		}
+		if start > end && (minute >= start || minute < end) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Location returns the schedule's timezone, or fallback when it doesn't have one
+func (s *Schedule) Location(fallback *time.Location) *time.Location {
+	if s == nil || s.Timezone == "" {
+		return fallback
+	}
+
+	location, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return fallback
+	}
+	return location
+}
+
+// parseTimeOfDay returns the number of minutes past midnight of a time such as 09:30
+func parseTimeOfDay(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("time of day %q must be formatted as 15:04", value)
+	}
+
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// checkSchedule checks that a schedule's window, timezone and rules are usable. Rule destinations
+// must be allowed by policy just like a link's own url. Routed links can't have rules since both
+// pick where a visit goes; their destinations' conditions cover the same needs.
+func checkSchedule(policy URLPolicy, routed bool) validation.RuleFunc {
+	return func(value interface{}) error {
+		schedule, _ := value.(*Schedule)
+		if schedule == nil {
+			return nil
+		}
+
+		if schedule.ActiveFrom != 0 && schedule.ActiveUntil != 0 && schedule.ActiveUntil <= schedule.ActiveFrom {
+			return errors.New("active_until must be after active_from")
+		}
+
+		if schedule.Timezone != "" {
+			_, err := time.LoadLocation(schedule.Timezone)
+			if err != nil {
+				return fmt.Errorf("unknown timezone %q", schedule.Timezone)
+			}
+		}
+
+		if routed && len(schedule.Rules) > 0 {
+			return errors.New("links with destinations can't have schedule rules")
+		}
+
+		if len(schedule.Rules) > MaxScheduleRules {
+			return fmt.Errorf("schedules can have at most %d rules", MaxScheduleRules)
+		}
+
+		for i, rule := range schedule.Rules {
+			for _, day := range rule.Days {
+				if !slices.Contains(Weekdays, day) {
+					return fmt.Errorf("rule %d: day %q must be one of %s", i, day, strings.Join(Weekdays, ", "))
+				}
+			}
+
+			for _, timeOfDay := range []string{rule.Start, rule.End} {
+				_, err := parseTimeOfDay(timeOfDay)
+				if err != nil {
+					return fmt.Errorf("rule %d: %w", i, err)
+				}
+			}
+
+			err := policy.Check(rule.URL)
+			if err != nil {
+				return fmt.Errorf("rule %d: %w", i, err)
+			}
+
+			err = validation.Validate(rule.URL, validation.Required, is.URL)
+			if err != nil {
+				return fmt.Errorf("rule %d: %w", i, err)
+			}
+		}
+
+		return nil
+	}
+}
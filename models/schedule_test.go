@@ -0,0 +1,87 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleMatch(t *testing.T) {
+	schedule := &Schedule{Rules: []ScheduleRule{
+		{Days: []string{"mon", "wed"}, Start: "09:00", End: "10:00", URL: "https://meet.example.com/room-a"},
+		{Days: []string{"tue"}, URL: "https://meet.example.com/room-b"},
+		{Start: "22:00", End: "06:00", URL: "https://status.example.com"},
+	}}
+
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("could not load location: %v", err)
+	}
+
+	tests := map[string]struct {
+		at   time.Time
+		want int
+	}{
+		"monday standup":         {time.Date(2024, 3, 4, 9, 30, 0, 0, location), 0},
+		"monday after standup":   {time.Date(2024, 3, 4, 10, 0, 0, 0, location), -1},
+		"all of tuesday":         {time.Date(2024, 3, 5, 23, 59, 0, 0, location), 1},
+		"overnight":              {time.Date(2024, 3, 6, 2, 0, 0, 0, location), 2},
+		"evaluated in location":  {time.Date(2024, 3, 4, 14, 30, 0, 0, time.UTC), 0},
+		"friday during the day":  {time.Date(2024, 3, 8, 12, 0, 0, 0, location), -1},
+		"overnight before start": {time.Date(2024, 3, 8, 21, 59, 0, 0, location), -1},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := schedule.Match(tc.at, location); got != tc.want {
+				t.Errorf("unexpected rule; want %d; got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	schedule := &Schedule{ActiveFrom: 1000, ActiveUntil: 2000}
+
+	for at, want := range map[int64]bool{999: false, 1000: true, 1999: true, 2000: false} {
+		if got := schedule.Active(time.Unix(at, 0)); got != want {
+			t.Errorf("unexpected result at %d; want %v; got %v", at, want, got)
+		}
+	}
+
+	var unscheduled *Schedule
+	if !unscheduled.Active(time.Now()) {
+		t.Errorf("links without a schedule should always be active")
+	}
+}
+
+func TestCheckSchedule(t *testing.T) {
+	tests := map[string]struct {
+		schedule *Schedule
+		routed   bool
+		valid    bool
+	}{
+		"valid": {
+			schedule: &Schedule{Timezone: "Europe/London", Rules: []ScheduleRule{
+				{Days: []string{"fri"}, Start: "16:00", URL: "https://example.com/weekend"},
+			}},
+			valid: true,
+		},
+		"window backwards": {schedule: &Schedule{ActiveFrom: 2000, ActiveUntil: 1000}},
+		"unknown timezone": {schedule: &Schedule{Timezone: "Mars/Olympus_Mons"}},
+		"unknown day":      {schedule: &Schedule{Rules: []ScheduleRule{{Days: []string{"friday"}, URL: "https://example.com"}}}},
+		"bad time":         {schedule: &Schedule{Rules: []ScheduleRule{{Start: "9am", URL: "https://example.com"}}}},
+		"denied url":       {schedule: &Schedule{Rules: []ScheduleRule{{URL: "https://pastebin.com/abc"}}}},
+		"missing url":      {schedule: &Schedule{Rules: []ScheduleRule{{Days: []string{"mon"}}}}},
+		"routed rules":     {schedule: &Schedule{Rules: []ScheduleRule{{URL: "https://example.com"}}}, routed: true},
+		"routed window":    {schedule: &Schedule{ActiveFrom: 1000, ActiveUntil: 2000}, routed: true, valid: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := checkSchedule(URLPolicy{DeniedDomains: []string{"pastebin.com"}}, tc.routed)(tc.schedule)
+			if (err == nil) != tc.valid {
+				t.Errorf("unexpected result; valid %v; got %v", tc.valid, err)
+			}
+		})
+	}
+}
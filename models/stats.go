@@ -0,0 +1,72 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Granularity is the length of time covered by a single bucket of hits
+type Granularity string
+
+const (
+	// Hourly buckets start on the hour, in UTC. They are only kept for a limited time.
+	Hourly Granularity = "hourly"
+	// Daily buckets start at midnight UTC
+	Daily Granularity = "daily"
+)
+
+// Granularities lists every granularity hits are recorded at
+var Granularities = []Granularity{Hourly, Daily}
+
+// ParseGranularity converts user input into a granularity
+func ParseGranularity(value string) (Granularity, error) {
+	for _, granularity := range Granularities {
+		if Granularity(value) == granularity {
+			return granularity, nil
+		}
+	}
+
+	return "", fmt.Errorf("granularity %q must be one of hourly, daily", value)
+}
+
+// Duration returns the length of time covered by a single bucket
+func (g Granularity) Duration() time.Duration {
+	if g == Hourly {
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// BucketStart returns the start of the bucket that t falls into
+func (g Granularity) BucketStart(t time.Time) time.Time {
+	return t.UTC().Truncate(g.Duration())
+}
+
+// HitBucket is the number of times a link was followed within one period of time
+type HitBucket struct {
+	Start int64 `json:"start"` // epoch time the bucket begins
+	Hits  int64 `json:"hits"`
+}
+
+// LinkStats is a time series of hits for a single link, or all links when ID is empty
+type LinkStats struct {
+	ID          string      `json:"id,omitempty"`
+	Granularity Granularity `json:"granularity"`
+	From        int64       `json:"from"` // epoch time of the first bucket
+	To          int64       `json:"to"`   // epoch time the last bucket ends
+	Total       int64       `json:"total"`
+	Buckets     []HitBucket `json:"buckets"`
+}
+
+// LinkHits is the number of times a link was followed within some window
+type LinkHits struct {
+	ID   string `json:"id"`
+	Hits int64  `json:"hits"`
+}
+
+// StaleReport lists links that haven't been followed since a cutoff, least recently used first
+type StaleReport struct {
+	Cutoff int64  `json:"cutoff"`           // epoch time the links haven't been used since
+	Action string `json:"action,omitempty"` // what the stale link policy did with the links, when sent by it
+	Links  []Link `json:"links"`
+}
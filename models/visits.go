@@ -0,0 +1,52 @@
+package models
+
+// VisitDimension is one attribute of a visit that is counted per link
+type VisitDimension string
+
+const (
+	// Referrers counts the host of the page each visit came from
+	Referrers VisitDimension = "referrers"
+	// UserAgents counts the browser family each visit was made with
+	UserAgents VisitDimension = "user_agents"
+	// Networks counts the client network each visit came from
+	Networks VisitDimension = "networks"
+)
+
+// VisitDimensions lists every attribute of a visit that is counted
+var VisitDimensions = []VisitDimension{Referrers, UserAgents, Networks}
+
+// Visit describes where a single redirect came from. Attributes that weren't collected are empty.
+type Visit struct {
+	Referrer  string // host of the referring page, ex. news.ycombinator.com
+	UserAgent string // browser family, ex. Firefox
+	Network   string // client network, possibly hashed
+}
+
+// Values returns the visit's value for each dimension that was collected
+func (v Visit) Values() map[VisitDimension]string {
+	values := map[VisitDimension]string{}
+	if v.Referrer != "" {
+		values[Referrers] = v.Referrer
+	}
+	if v.UserAgent != "" {
+		values[UserAgents] = v.UserAgent
+	}
+	if v.Network != "" {
+		values[Networks] = v.Network
+	}
+	return values
+}
+
+// VisitCount is the number of visits to a link sharing a value
+type VisitCount struct {
+	Value string `json:"value"`
+	Hits  int64  `json:"hits"`
+}
+
+// VisitBreakdown is where a link's visits came from, most common first
+type VisitBreakdown struct {
+	ID         string       `json:"id"`
+	Referrers  []VisitCount `json:"referrers"`
+	UserAgents []VisitCount `json:"user_agents"`
+	Networks   []VisitCount `json:"networks"`
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+)
+
+// openAPISpec describes the versioned api. It is checked against the registered routes and
+// handler responses in tests so that it can't drift from what is actually served.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPIHandler serves the openapi document with its server url set to basePath, the path
+// the versioned api is mounted under.
+func openAPIHandler(basePath string) (http.Handler, error) {
+	spec := map[string]interface{}{}
+	err := json.Unmarshal(openAPISpec, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	spec["servers"] = []map[string]string{{"url": basePath}}
+
+	document, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(document)
+	}), nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/clintjedwards/goto/config"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+)
+
+// openAPIDocument holds the parts of the openapi spec that are checked against the handlers
+type openAPIDocument struct {
+	Paths map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+func parseOpenAPISpec(t *testing.T) openAPIDocument {
+	t.Helper()
+
+	var document openAPIDocument
+	err := json.Unmarshal(openAPISpec, &document)
+	if err != nil {
+		t.Fatalf("could not parse openapi spec: %v", err)
+	}
+
+	return document
+}
+
+// documentedStatuses returns the response codes documented for the given path and method
+func (d openAPIDocument) documentedStatuses(t *testing.T, path, method string) []int {
+	t.Helper()
+
+	var operation struct {
+		Responses map[string]json.RawMessage `json:"responses"`
+	}
+	err := json.Unmarshal(d.Paths[path][strings.ToLower(method)], &operation)
+	if err != nil {
+		t.Fatalf("could not parse %s %s operation: %v", method, path, err)
+	}
+
+	statuses := []int{}
+	for code := range operation.Responses {
+		status, _ := strconv.Atoi(code)
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+func newTestApp(t *testing.T) *app {
+	t.Helper()
+
+	engine := newTestBoltEngine(t, "goto.db")
+	app := &app{storage: engine, hits: newHitRecorder(engine), networkSalt: []byte("salt")}
+	app.config.Store(&config.Config{
+		MaxIDLength: 50,
+		MaxHops:     5,
+		Database:    &config.DatabaseConfig{Engine: "bolt"},
+		Visits:      &config.VisitsConfig{Referrers: true, UserAgents: true, Networks: "hashed"},
+		Stale:       &config.StaleConfig{Action: "notify"},
+		Policy:      &config.PolicyConfig{DeniedDomains: []string{"pastebin.com"}},
+		IDs:         &config.IDsConfig{Alphabet: "23456789bcdfghjkmnpqrstvwxyz", Length: 6},
+	})
+
+	return app
+}
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	document := parseOpenAPISpec(t)
+
+	router, err := newTestApp(t).newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	registered := []string{}
+	_ = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, _ := route.GetPathTemplate()
+		methods, ok := route.GetHandler().(handlers.MethodHandler)
+		if !ok || !strings.HasPrefix(template, apiVersionPath+"/") {
+			return nil
+		}
+
+		for method := range methods {
+			registered = append(registered, method+" "+strings.TrimPrefix(template, apiVersionPath))
+		}
+		return nil
+	})
+
+	documented := []string{}
+	for path, item := range document.Paths {
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	sort.Strings(registered)
+	sort.Strings(documented)
+
+	if strings.Join(registered, "\n") != strings.Join(documented, "\n") {
+		t.Errorf("openapi spec does not match registered routes;\nregistered:\n%s\ndocumented:\n%s",
+			strings.Join(registered, "\n"), strings.Join(documented, "\n"))
+	}
+}
+
+func TestOpenAPIMatchesResponses(t *testing.T) {
+	document := parseOpenAPISpec(t)
+
+	router, err := newTestApp(t).newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	// Requests are made in order since later ones depend on links created by earlier ones
+	tests := []struct {
+		method string
+		path   string
+		route  string
+		body   string
+		want   int
+	}{
+		{"POST", "/links", "/links", `{"id": "github", "url": "https://github.com"}`, http.StatusCreated},
+		{"POST", "/links", "/links", `{"id": "github", "url": "https://github.com"}`, http.StatusConflict},
+		{"POST", "/links", "/links", `{"id": "links", "url": "https://github.com"}`, http.StatusBadRequest},
+		{"POST", "/links", "/links", `{"id": "paste", "url": "https://pastebin.com/abc"}`, http.StatusBadRequest},
+		{"POST", "/policy/check", "/policy/check", `{"url": "https://pastebin.com/abc"}`, http.StatusOK},
+		{"POST", "/policy/check", "/policy/check", `{}`, http.StatusBadRequest},
+		{"POST", "/links", "/links", `{"url": "https://github.com/clintjedwards"}`, http.StatusCreated},
+		{"POST", "/links", "/links", `{"id": "pager", "url": "https://pager.example.com", "description": "Page the on call engineer", "tags": ["oncall"], "labels": {"owner": "sre"}}`, http.StatusCreated},
+		{"POST", "/links", "/links", `{"id": "pager2", "url": "https://pager.example.com", "tags": ["on call"]}`, http.StatusBadRequest},
+		{"POST", "/links", "/links", `{"id": "standup", "url": "https://meet.example.com", "schedule": {"timezone": "Europe/London", "rules": [{"days": ["mon"], "start": "09:00", "end": "09:30", "url": "https://meet.example.com/room-a"}]}}`, http.StatusCreated},
+		{"POST", "/links", "/links", `{"id": "standup2", "url": "https://meet.example.com", "schedule": {"rules": [{"days": ["monday"], "url": "https://meet.example.com/room-a"}]}}`, http.StatusBadRequest},
+		{"POST", "/suggestions", "/suggestions", `{"url": "https://github.com/clintjedwards/goto", "title": "Goto"}`, http.StatusOK},
+		{"POST", "/suggestions", "/suggestions", `{"url": "https://pastebin.com/abc"}`, http.StatusBadRequest},
+		{"GET", "/links", "/links", "", http.StatusOK},
+		{"GET", "/links?tag=oncall", "/links", "", http.StatusOK},
+		{"GET", "/tags", "/tags", "", http.StatusOK},
+		{"GET", "/links/github", "/links/{id}", "", http.StatusOK},
+		{"GET", "/links/missing", "/links/{id}", "", http.StatusNotFound},
+		{"GET", "/links/github/stats", "/links/{id}/stats", "", http.StatusOK},
+		{"GET", "/links/github/stats?granularity=weekly", "/links/{id}/stats", "", http.StatusBadRequest},
+		{"GET", "/links/missing/stats", "/links/{id}/stats", "", http.StatusNotFound},
+		{"POST", "/links/github/unarchive", "/links/{id}/unarchive", "", http.StatusOK},
+		{"GET", "/links/github/preview?at=2024-03-04T09:00:00Z", "/links/{id}/preview", "", http.StatusOK},
+		{"GET", "/links/github/preview?at=tomorrow", "/links/{id}/preview", "", http.StatusBadRequest},
+		{"GET", "/links/missing/preview", "/links/{id}/preview", "", http.StatusNotFound},
+		{"POST", "/links/missing/unarchive", "/links/{id}/unarchive", "", http.StatusNotFound},
+		{"GET", "/reports/stale?older_than=30d", "/reports/stale", "", http.StatusOK},
+		{"GET", "/reports/stale?older_than=never", "/reports/stale", "", http.StatusBadRequest},
+		{"GET", "/reports/broken", "/reports/broken", "", http.StatusOK},
+		{"GET", "/links/github/visits", "/links/{id}/visits", "", http.StatusOK},
+		{"GET", "/links/github/visits?limit=0", "/links/{id}/visits", "", http.StatusBadRequest},
+		{"GET", "/links/missing/visits", "/links/{id}/visits", "", http.StatusNotFound},
+		{"GET", "/stats?granularity=hourly", "/stats", "", http.StatusOK},
+		{"GET", "/stats?from=2024-01-02&to=2024-01-01", "/stats", "", http.StatusBadRequest},
+		{"GET", "/stats/top?window=30d&order=asc", "/stats/top", "", http.StatusOK},
+		{"GET", "/stats/top?window=soon", "/stats/top", "", http.StatusBadRequest},
+		{"DELETE", "/links/github", "/links/{id}", "", http.StatusOK},
+		{"GET", "/backup", "/backup", "", http.StatusOK},
+		{"GET", "/health", "/health", "", http.StatusOK},
+		{"GET", "/status", "/status", "", http.StatusOK},
+		{"GET", "/version", "/version", "", http.StatusOK},
+		{"GET", "/openapi.json", "/openapi.json", "", http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(tc.method, apiVersionPath+tc.path, strings.NewReader(tc.body))
+		router.ServeHTTP(recorder, req)
+
+		if recorder.Code != tc.want {
+			t.Errorf("%s %s: unexpected status; want %d; got %d", tc.method, tc.path, tc.want, recorder.Code)
+		}
+
+		documented := false
+		for _, status := range document.documentedStatuses(t, tc.route, tc.method) {
+			if status == recorder.Code {
+				documented = true
+			}
+		}
+		if !documented {
+			t.Errorf("%s %s: status %d is not documented in the openapi spec", tc.method, tc.route, recorder.Code)
+		}
+	}
+}
+
+func TestLegacyRoutesDeprecated(t *testing.T) {
+	router, err := newTestApp(t).newRouter("/_")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/_/links/github", nil))
+
+	if recorder.Header().Get("Deprecation") != "true" {
+		t.Errorf("legacy route should be marked deprecated")
+	}
+	if link := recorder.Header().Get("Link"); link != `</_/api/v1/links/github>; rel="successor-version"` {
+		t.Errorf("unexpected successor link; got %q", link)
+	}
+}
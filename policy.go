@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+	"github.com/rs/zerolog/log"
+)
+
+// urlPolicy returns the currently configured restrictions on where links may point
+func (app *app) urlPolicy() models.URLPolicy {
+	policy := app.currentConfig().Policy
+	return models.URLPolicy{
+		AllowedSchemes: policy.AllowedSchemes,
+		AllowedDomains: policy.AllowedDomains,
+		DeniedDomains:  policy.DeniedDomains,
+	}
+}
+
+// policyCheckRequest is a url to test against the url policy
+type policyCheckRequest struct {
+	URL string `json:"url"`
+}
+
+// policyCheckResult is whether a url would be allowed by the url policy, and if not, why
+type policyCheckResult struct {
+	URL     string `json:"url"`
+	Allowed bool   `json:"allowed"`
+	Rule    string `json:"rule,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// checkPolicyHandler tests a url against the url policy without creating a link
+func (app *app) checkPolicyHandler(w http.ResponseWriter, req *http.Request) {
+	request := policyCheckRequest{}
+
+	err := parseJSON(req.Body, &request)
+	if err != nil {
+		log.Warn().Err(err).Msg("could not parse json")
+		sendErrResponse(w, req, utilErrors.New(utilErrors.CodeInvalidRequest, err.Error()))
+		return
+	}
+	req.Body.Close()
+
+	if request.URL == "" {
+		sendErrResponse(w, req, utilErrors.New(utilErrors.CodeInvalidRequest, "url is required"))
+		return
+	}
+
+	result := policyCheckResult{URL: request.URL, Allowed: true}
+
+	var violation *models.PolicyViolation
+	if errors.As(app.urlPolicy().Check(request.URL), &violation) {
+		result.Allowed = false
+		result.Rule = violation.Rule
+		result.Reason = violation.Message
+	}
+
+	sendResponse(w, http.StatusOK, result)
+}
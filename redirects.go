@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+)
+
+// defaultPorts are the ports assumed for addresses that don't include one
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// expandLink returns where following link with requestURI leads. requestURI is the path and
+// query the link was followed with, starting with the link's short name.
+func expandLink(link models.Link, requestURI string) string {
+	if link.Kind == models.Formatted {
+		return generateFormattedLink(requestURI[1:], link.URL)
+	}
+	return link.URL + requestURI[len(link.ID)+1:]
+}
+
+// pointsAtServer reports whether destination is on this server. The server is known by the host
+// the request was made to, the addresses it listens on and any configured aliases. Aliases
+// without a port match any port.
+func (app *app) pointsAtServer(destination *url.URL, requestHost string) bool {
+	config := app.currentConfig()
+
+	host, port, _ := splitAddress(destination.Host, destination.Scheme)
+	if host == "" {
+		return false
+	}
+
+	addresses := []string{requestHost, config.Host}
+	if config.TLS != nil && config.TLS.Enabled() {
+		addresses = append(addresses, config.TLS.Host)
+	}
+
+	for _, address := range addresses {
+		serverHost, serverPort, _ := splitAddress(address, destination.Scheme)
+		if serverHost == host && serverPort == port {
+			return true
+		}
+	}
+
+	for _, alias := range config.Aliases {
+		aliasHost, aliasPort, hasPort := splitAddress(alias, destination.Scheme)
+		if aliasHost == host && (!hasPort || aliasPort == port) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitAddress normalizes the host of address so that equivalent spellings compare equal and
+// fills in the default port for scheme when there isn't one. Unspecified addresses such as
+// 0.0.0.0, which only appear in listening addresses, are returned as an empty host.
+func splitAddress(address, scheme string) (host, port string, hasPort bool) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = strings.Trim(address, "[]"), ""
+	}
+
+	hasPort = port != ""
+	if !hasPort {
+		port = defaultPorts[strings.ToLower(scheme)]
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if addr.IsUnspecified() {
+			return "", port, hasPort
+		}
+		host = addr.Unmap().String()
+	}
+
+	return host, port, hasPort
+}
+
+// linkTarget returns the short name destination follows if it is a link on this server, along
+// with the path and query it is followed with
+func (app *app) linkTarget(destination, requestHost string) (id, requestURI string, found bool) {
+	parsed, err := url.Parse(destination)
+	if err != nil || !app.pointsAtServer(parsed, requestHost) {
+		return "", "", false
+	}
+
+	requestURI = parsed.RequestURI()
+	segments := strings.FieldsFunc(requestURI[1:], isReservedCharacter)
+	if len(segments) == 0 || slices.Contains(app.routeIDs, segments[0]) {
+		return "", "", false
+	}
+
+	return segments[0], requestURI, true
+}
+
+// linkURLs returns every url link can send visitors to, starting with its own
+func linkURLs(link models.Link) []string {
+	urls := []string{link.URL}
+	if link.Schedule != nil {
+		for _, rule := range link.Schedule.Rules {
+			urls = append(urls, rule.URL)
+		}
+	}
+	for _, destination := range link.Destinations {
+		urls = append(urls, destination.URL)
+	}
+	return append(urls, link.Fallbacks...)
+}
+
+// checkRedirectChain follows each of a new link's urls through any other links on this server
+// they point at, rejecting it if any would lead back to itself or pass through more links than
+// allowed. Links that don't exist yet end the chain; they are checked when they are created.
+func (app *app) checkRedirectChain(ctx context.Context, link *models.Link, requestHost string) error {
+	chains := redirectChains{
+		app:         app,
+		requestHost: requestHost,
+		maxHops:     app.currentConfig().MaxHops,
+		followed:    map[string]int{},
+	}
+
+	for _, destination := range linkURLs(*link) {
+		err := chains.follow(ctx, []string{link.ID}, destination)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// redirectChains walks the links a new link can lead through. Every url of each link reached is
+// followed, since any of them may be the one a visit takes.
+type redirectChains struct {
+	app         *app
+	requestHost string
+	maxHops     int
+	// followed holds destinations already followed without problems and the longest chain they
+	// were reached by. Reaching one again by a chain no longer can't find anything new.
+	followed map[string]int
+}
+
+// follow checks where destination leads after being reached through chain
+func (c *redirectChains) follow(ctx context.Context, chain []string, destination string) error {
+	id, requestURI, found := c.app.linkTarget(destination, c.requestHost)
+	if !found {
+		return nil
+	}
+
+	if slices.Contains(chain, id) {
+		return utilErrors.New(utilErrors.CodeRedirectLoop, fmt.Sprintf("url redirects back to %s through %s",
+			id, strings.Join(append(chain, id), " -> ")))
+	}
+
+	if length, found := c.followed[destination]; found && len(chain) <= length {
+		return nil
+	}
+
+	next, err := c.app.storage.GetLink(ctx, id)
+	if err != nil {
+		if errors.Is(err, utilErrors.ErrNotFound) {
+			return nil
+		}
+		return utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve link", err)
+	}
+
+	if len(chain) > c.maxHops {
+		return utilErrors.New(utilErrors.CodeRedirectLoop, fmt.Sprintf("url redirects through more than %d other links",
+			c.maxHops))
+	}
+
+	chain = append(slices.Clip(chain), id)
+	for _, nextURL := range linkURLs(next) {
+		variant := next
+		variant.URL = nextURL
+		variant.Kind = models.KindOf(nextURL)
+
+		err := c.follow(ctx, chain, expandLink(variant, requestURI))
+		if err != nil {
+			return err
+		}
+	}
+
+	c.followed[destination] = len(chain) - 1
+	return nil
+}
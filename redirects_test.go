@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/clintjedwards/goto/config"
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+)
+
+func TestPointsAtServer(t *testing.T) {
+	app := &app{}
+	app.config.Store(&config.Config{
+		Host:    "0.0.0.0:8080",
+		TLS:     &config.TLSConfig{},
+		Aliases: []string{"go", "Go.Corp.Example.", "10.0.0.5:8080"},
+	})
+
+	tests := map[string]bool{
+		"http://localhost:8080/github":         true,
+		"http://LOCALHOST:8080/github":         true,
+		"http://localhost:9090/github":         false,
+		"http://go/github":                     true,
+		"https://go:8443/github":               true,
+		"http://go.corp.example./github":       true,
+		"http://10.0.0.5:8080/github":          true,
+		"http://10.0.0.5/github":               false,
+		"http://[::ffff:10.0.0.5]:8080/github": true,
+		"http://0.0.0.0:8080/github":           false,
+		"https://github.com/go":                false,
+	}
+
+	for destination, want := range tests {
+		parsed, err := url.Parse(destination)
+		if err != nil {
+			t.Fatalf("could not parse %s: %v", destination, err)
+		}
+
+		if got := app.pointsAtServer(parsed, "localhost:8080"); got != want {
+			t.Errorf("unexpected result for %s; want %v; got %v", destination, want, got)
+		}
+	}
+}
+
+func TestRedirectChains(t *testing.T) {
+	app := newTestApp(t)
+	app.config.Load().Aliases = []string{"go"}
+	router, err := app.newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	createRequest := func(request models.CreateLinkRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(request)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("POST", apiVersionPath+"/links", strings.NewReader(string(body))))
+		return recorder
+	}
+	create := func(id, destination string) *httptest.ResponseRecorder {
+		return createRequest(models.CreateLinkRequest{ID: id, URL: destination})
+	}
+
+	for id, destination := range map[string]string{
+		"docs": "https://wiki.example.com/docs",
+		"wiki": "http://go/docs",
+		"help": "http://example.com/wiki",
+	} {
+		if recorder := create(id, destination); recorder.Code != http.StatusCreated {
+			t.Fatalf("could not create %s; status %d: %s", id, recorder.Code, recorder.Body)
+		}
+	}
+
+	// Links that don't exist yet can be pointed at, so loops are caught when they are closed
+	if recorder := create("next", "http://go/cycle"); recorder.Code != http.StatusCreated {
+		t.Fatalf("could not create next; status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	// Every url of the links reached is followed, not just their own
+	if recorder := createRequest(models.CreateLinkRequest{ID: "rota", URL: "https://rota.example.com",
+		Schedule: &models.Schedule{Rules: []models.ScheduleRule{{Days: []string{"sat", "sun"}, URL: "http://go/oncall"}}},
+	}); recorder.Code != http.StatusCreated {
+		t.Fatalf("could not create rota; status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	if recorder := createRequest(models.CreateLinkRequest{ID: "dash", URL: "https://dash.example.com",
+		Destinations: []models.Destination{{URL: "http://go/mobile", Condition: &models.Condition{Type: models.ConditionUserAgent, Value: "mobile"}}},
+	}); recorder.Code != http.StatusCreated {
+		t.Fatalf("could not create dash; status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	loops := map[string]models.CreateLinkRequest{
+		"self":  {URL: "http://go/self/page"},
+		"cycle": {URL: "http://example.com/next"},
+		"scheduled": {URL: "https://example.com", Schedule: &models.Schedule{Rules: []models.ScheduleRule{
+			{Start: "18:00", URL: "http://go/scheduled"},
+		}}},
+		"oncall": {URL: "http://go/rota"},
+		"routed": {URL: "https://example.com", Destinations: []models.Destination{
+			{URL: "https://example.com/canary", Weight: 1}, {URL: "http://go/routed", Weight: 99},
+		}},
+		"mobile":   {URL: "http://go/dash"},
+		"failover": {URL: "https://example.com", Fallbacks: []string{"https://status.example.com", "http://go/failover"}},
+	}
+
+	for id, request := range loops {
+		request.ID = id
+		recorder := createRequest(request)
+		var body struct {
+			Error utilErrors.APIError `json:"error"`
+		}
+		_ = json.NewDecoder(recorder.Body).Decode(&body)
+		if recorder.Code != http.StatusBadRequest || body.Error.Code != utilErrors.CodeRedirectLoop {
+			t.Errorf("%s should be rejected as a loop; got status %d and code %q", id, recorder.Code, body.Error.Code)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/wiki/setup?lang=go", nil))
+	if recorder.Code != http.StatusMovedPermanently {
+		t.Fatalf("unexpected status; want %d; got %d", http.StatusMovedPermanently, recorder.Code)
+	}
+	if location := recorder.Header().Get("Location"); location != "https://wiki.example.com/docs/setup?lang=go" {
+		t.Errorf("chain should be followed to its end; got %s", location)
+	}
+
+	// Loops created directly in storage bypass validation and are stopped by the hop limit
+	for _, link := range []models.Link{{ID: "ping", URL: "http://go/pong"}, {ID: "pong", URL: "http://go/ping"}} {
+		err = app.storage.CreateLink(context.Background(), &link)
+		if err != nil {
+			t.Fatalf("could not create link: %v", err)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/ping", nil))
+	if recorder.Code != http.StatusLoopDetected {
+		t.Errorf("unexpected status; want %d; got %d", http.StatusLoopDetected, recorder.Code)
+	}
+}
+
+func TestFollowLinkWithoutID(t *testing.T) {
+	app := newTestApp(t)
+	router, err := app.newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	for _, path := range []string{"/", "/?", "/+/", "/:@"} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("unexpected status for %q; want %d; got %d", path, http.StatusNotFound, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/clintjedwards/goto/config"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// configReloadInterval is how often the config file is checked for changes
+const configReloadInterval = 10 * time.Second
+
+// reloadConfig loads the configuration again and applies the settings that can change while
+// running. A configuration that fails to load or validate leaves the current one in place.
+func (app *app) reloadConfig() error {
+	loaded, err := config.Load(app.configPath)
+	if err != nil {
+		return err
+	}
+
+	current := app.currentConfig()
+	reloaded := current.WithReloaded(loaded)
+
+	if !reflect.DeepEqual(reloaded, loaded) {
+		log.Warn().Msg("some changed settings only take effect after a restart")
+	}
+
+	app.config.Store(reloaded)
+	zerolog.SetGlobalLevel(parseLogLevel(reloaded.LogLevel))
+
+	log.Info().Str("loglevel", reloaded.LogLevel).Int("max_id_length", reloaded.MaxIDLength).
+		Int("reserved_ids", len(reloaded.ReservedIDs)).Int("auth_tokens", len(reloaded.AuthTokens)).
+		Msg("reloaded configuration")
+	return nil
+}
+
+// watchConfig reloads the configuration whenever the config file is modified until ctx is
+// canceled. It returns immediately if configuration is only read from the environment.
+func (app *app) watchConfig(ctx context.Context) {
+	if app.configPath == "" {
+		return
+	}
+
+	lastModTime, err := latestModTime(app.configPath)
+	if err != nil {
+		log.Error().Err(err).Str("path", app.configPath).Msg("could not watch config file")
+	}
+
+	ticker := time.NewTicker(configReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			modTime, err := latestModTime(app.configPath)
+			if err != nil {
+				log.Error().Err(err).Str("path", app.configPath).Msg("could not check config file for changes")
+				continue
+			}
+			if modTime.Equal(lastModTime) {
+				continue
+			}
+			lastModTime = modTime
+
+			err = app.reloadConfig()
+			if err != nil {
+				log.Error().Err(err).Str("path", app.configPath).Msg("could not reload configuration; keeping previous")
+			}
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// apiVersionPath is where the current version of the api is mounted beneath the api prefix
+const apiVersionPath = "/api/v1"
+
+// newRouter registers the app's routes. The management api is mounted under apiPrefix,
+// leaving everything else to short links. An empty prefix mounts the api at the root.
+//
+// Short names that would collide with a registered route are recorded so that links
+// can't be created for them.
+func (app *app) newRouter(apiPrefix string) (*mux.Router, error) {
+	router := mux.NewRouter()
+
+	versionPath := apiPrefix + apiVersionPath
+	v1 := router.PathPrefix(versionPath).Subrouter()
+
+	openAPI, err := openAPIHandler(versionPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not load openapi spec: %w", err)
+	}
+
+	v1.Handle("/links", handlers.MethodHandler{
+		"GET":  http.HandlerFunc(app.listLinksHandler),
+		"POST": app.authenticated(http.HandlerFunc(app.createLinkHandler)),
+	})
+
+	v1.Handle("/links/{id}", handlers.MethodHandler{
+		"GET":    http.HandlerFunc(app.getLinkHandler),
+		"DELETE": app.authenticated(http.HandlerFunc(app.deleteLinksHandler)),
+	})
+
+	v1.Handle("/links/{id}/unarchive", handlers.MethodHandler{
+		"POST": app.authenticated(http.HandlerFunc(app.unarchiveLinkHandler)),
+	})
+
+	v1.Handle("/links/{id}/preview", handlers.MethodHandler{
+		"GET": http.HandlerFunc(app.previewLinkHandler),
+	})
+
+	v1.Handle("/links/{id}/stats", handlers.MethodHandler{
+		"GET": http.HandlerFunc(app.linkStatsHandler),
+	})
+
+	v1.Handle("/links/{id}/visits", handlers.MethodHandler{
+		"GET": http.HandlerFunc(app.linkVisitsHandler),
+	})
+
+	v1.Handle("/tags", handlers.MethodHandler{
+		"GET": http.HandlerFunc(app.listTagsHandler),
+	})
+
+	v1.Handle("/stats", handlers.MethodHandler{
+		"GET": http.HandlerFunc(app.globalStatsHandler),
+	})
+
+	v1.Handle("/stats/top", handlers.MethodHandler{
+		"GET": http.HandlerFunc(app.topLinksHandler),
+	})
+
+	v1.Handle("/reports/stale", handlers.MethodHandler{
+		"GET": http.HandlerFunc(app.staleReportHandler),
+	})
+
+	v1.Handle("/reports/broken", handlers.MethodHandler{
+		"GET": http.HandlerFunc(app.brokenReportHandler),
+	})
+
+	v1.Handle("/policy/check", handlers.MethodHandler{
+		"POST": http.HandlerFunc(app.checkPolicyHandler),
+	})
+
+	v1.Handle("/suggestions", handlers.MethodHandler{
+		"POST": app.authenticated(http.HandlerFunc(app.suggestIDsHandler)),
+	})
+
+	v1.Handle("/backup", handlers.MethodHandler{
+		"GET": app.authenticated(http.HandlerFunc(app.backupHandler)),
+	})
+
+	v1.Handle("/health", handlers.MethodHandler{
+		"GET": http.HandlerFunc(app.healthHandler),
+	})
+
+	v1.Handle("/status", handlers.MethodHandler{
+		"GET": http.HandlerFunc(app.statusHandler),
+	})
+
+	v1.Handle("/version", handlers.MethodHandler{
+		"GET": http.HandlerFunc(app.versionHandler),
+	})
+
+	v1.Handle("/openapi.json", handlers.MethodHandler{
+		"GET": openAPI,
+	})
+
+	api := router
+	if apiPrefix != "" {
+		api = router.PathPrefix(apiPrefix).Subrouter()
+	}
+
+	api.Handle("/metrics", handlers.MethodHandler{
+		"GET": promhttp.Handler(),
+	})
+
+	// Routes from before the api was versioned are kept for existing clients, but point
+	// them towards their replacement.
+	legacyRoutes := []struct {
+		path      string
+		successor string
+		handler   http.Handler
+	}{
+		{"/links", "/links", handlers.MethodHandler{
+			"GET": http.HandlerFunc(app.listLinksHandler),
+		}},
+		{"/links/{id}", "/links/{id}", handlers.MethodHandler{
+			"GET":    http.HandlerFunc(app.getLinkHandler),
+			"DELETE": app.authenticated(http.HandlerFunc(app.deleteLinksHandler)),
+		}},
+		{"/create", "/links", handlers.MethodHandler{
+			"POST": app.authenticated(http.HandlerFunc(app.createLinkHandler)),
+		}},
+		{"/backup", "/backup", handlers.MethodHandler{
+			"GET": app.authenticated(http.HandlerFunc(app.backupHandler)),
+		}},
+		{"/health", "/health", handlers.MethodHandler{
+			"GET": http.HandlerFunc(app.healthHandler),
+		}},
+		{"/status", "/status", handlers.MethodHandler{
+			"GET": http.HandlerFunc(app.statusHandler),
+		}},
+		{"/version", "/version", handlers.MethodHandler{
+			"GET": http.HandlerFunc(app.versionHandler),
+		}},
+	}
+
+	for _, route := range legacyRoutes {
+		api.Handle(route.path, deprecated(versionPath+route.successor, route.handler))
+	}
+
+	router.PathPrefix("/").Handler(handlers.MethodHandler{
+		"GET": http.HandlerFunc(app.followLinkHandler),
+	})
+
+	router.Use(requestIDMiddleware, metricsMiddleware, tracingMiddleware)
+
+	app.routeIDs = routeIDs(router)
+
+	return router, nil
+}
+
+// deprecated marks responses from next as coming from a deprecated route and links to the
+// route replacing it. Variables in the successor's path are filled in from the request.
+func deprecated(successor string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		link := successor
+		for name, value := range mux.Vars(req) {
+			link = strings.ReplaceAll(link, "{"+name+"}", value)
+		}
+
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", link))
+		next.ServeHTTP(w, req)
+	})
+}
+
+// routeIDs returns the first path segment of every route registered on router. These are
+// the short names that could never be reached since the route would be matched instead.
+func routeIDs(router *mux.Router) []string {
+	seen := map[string]struct{}{}
+
+	_ = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+
+		segment, _, _ := strings.Cut(strings.TrimPrefix(template, "/"), "/")
+		if segment != "" {
+			seen[segment] = struct{}{}
+		}
+
+		return nil
+	})
+
+	ids := []string{}
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// uiIDs are short names kept for the web ui's pages, which are served in front of the app rather
+// than by it, so they are reserved wherever the api is
+var uiIDs = []string{"edit"}
+
+// reservedIDs returns the short names that links can't be created with; those taken by the
+// app's own routes and the web ui, and any reserved through configuration.
+func (app *app) reservedIDs() []string {
+	ids := append(append([]string{}, app.routeIDs...), uiIDs...)
+	return append(ids, app.currentConfig().ReservedIDs...)
+}
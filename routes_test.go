@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/clintjedwards/goto/config"
+)
+
+func TestReservedIDs(t *testing.T) {
+	tests := map[string]struct {
+		apiPrefix string
+		extras    []string
+		want      string
+	}{
+		"api at root": {
+			apiPrefix: "",
+			want:      "api,backup,create,health,links,metrics,status,version,edit",
+		},
+		"api under prefix": {
+			apiPrefix: "/_",
+			want:      "_,edit",
+		},
+		"configured extras": {
+			apiPrefix: "/_",
+			extras:    []string{"admin"},
+			want:      "_,edit,admin",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			app := &app{}
+			app.config.Store(&config.Config{ReservedIDs: tc.extras})
+			_, err := app.newRouter(tc.apiPrefix)
+			if err != nil {
+				t.Fatalf("could not create router: %v", err)
+			}
+
+			got := strings.Join(app.reservedIDs(), ",")
+			if got != tc.want {
+				t.Errorf("unexpected reserved ids; want %q; got %q", tc.want, got)
+			}
+		})
+	}
+}
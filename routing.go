@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strings"
+
+	"github.com/clintjedwards/goto/models"
+)
+
+// visitor returns what destination conditions know about the visit req. Groups are only
+// known when an authenticating proxy in front of the server is configured to pass them on.
+func (app *app) visitor(req *http.Request) models.Visitor {
+	visitor := models.Visitor{
+		UserAgent: req.UserAgent(),
+		Header:    req.Header,
+		Query:     req.URL.Query(),
+	}
+
+	header := app.currentConfig().GroupsHeader
+	if header == "" {
+		return visitor
+	}
+
+	for _, value := range req.Header.Values(header) {
+		for _, group := range strings.Split(value, ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				visitor.Groups = append(visitor.Groups, group)
+			}
+		}
+	}
+
+	return visitor
+}
+
+// routedLink returns link as it redirects for visitor, with its url replaced by that of the
+// destination it routes to. The index of the destination is also returned, or -1 when the link
+// isn't routed or no destination applies.
+func routedLink(link models.Link, visitor models.Visitor) (models.Link, int) {
+	if link.Kind != models.Routed {
+		return link, -1
+	}
+
+	destination := link.Route(visitor, rand.IntN)
+	if destination >= 0 {
+		link.URL = link.Destinations[destination].URL
+	}
+	link.Kind = models.KindOf(link.URL)
+
+	return link, destination
+}
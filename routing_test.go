@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/clintjedwards/goto/models"
+)
+
+func TestRoutedLinks(t *testing.T) {
+	app := newTestApp(t)
+	app.config.Load().GroupsHeader = "X-Forwarded-Groups"
+	router, err := app.newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	body, _ := json.Marshal(models.CreateLinkRequest{
+		ID:  "dash",
+		URL: "https://dash.example.com",
+		Destinations: []models.Destination{
+			{URL: "https://m.dash.example.com", Condition: &models.Condition{Type: models.ConditionUserAgent, Value: "mobile"}},
+			{URL: "https://admin.dash.example.com", Condition: &models.Condition{Type: models.ConditionGroup, Value: "admins"}},
+			{URL: "https://canary.dash.example.com", Condition: &models.Condition{Type: models.ConditionQuery, Name: "canary"}, Hits: 100},
+		},
+	})
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", apiVersionPath+"/links", strings.NewReader(string(body))))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("could not create link; status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	tests := map[string]struct {
+		path   string
+		header http.Header
+		want   string
+	}{
+		"mobile":       {"/dash/home", http.Header{"User-Agent": {"Mozilla/5.0 (Linux; Android 14) Mobile Safari"}}, "https://m.dash.example.com/home"},
+		"admins":       {"/dash", http.Header{"X-Forwarded-Groups": {"sre, admins"}}, "https://admin.dash.example.com"},
+		"query":        {"/dash?canary", nil, "https://canary.dash.example.com?canary"},
+		"no condition": {"/dash", nil, "https://dash.example.com"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			for key, values := range tc.header {
+				req.Header[key] = values
+			}
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			if location := recorder.Header().Get("Location"); location != tc.want {
+				t.Errorf("unexpected destination; want %s; got %s", tc.want, location)
+			}
+		})
+	}
+
+	err = app.hits.drain(context.Background())
+	if err != nil {
+		t.Fatalf("could not drain hit recorder: %v", err)
+	}
+
+	link, err := app.storage.GetLink(context.Background(), "dash")
+	if err != nil {
+		t.Fatalf("could not retrieve link: %v", err)
+	}
+
+	if link.Kind != models.Routed || link.Hits != 4 {
+		t.Errorf("unexpected link; want a routed link with 4 hits; got %s with %d", link.Kind, link.Hits)
+	}
+	for i, destination := range link.Destinations {
+		if destination.Hits != 1 {
+			t.Errorf("destination %d should have been chosen once; got %d hits", i, destination.Hits)
+		}
+	}
+}
+
+func TestConcurrentRoutedVisits(t *testing.T) {
+	app := newTestApp(t)
+	router, err := app.newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	link := models.CreateLinkRequest{ID: "dash", URL: "https://dash.example.com", Destinations: []models.Destination{
+		{URL: "https://canary.dash.example.com", Weight: 1},
+		{URL: "https://dash.example.com", Weight: 3},
+	}}.ToLink()
+	if err := app.storage.CreateLink(context.Background(), link); err != nil {
+		t.Fatalf("could not create link: %v", err)
+	}
+
+	const visits = 50
+	wg := sync.WaitGroup{}
+	for i := 0; i < visits; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/dash", nil))
+		}()
+	}
+	wg.Wait()
+
+	if err := app.hits.drain(context.Background()); err != nil {
+		t.Fatalf("could not drain hit recorder: %v", err)
+	}
+
+	got, err := app.storage.GetLink(context.Background(), "dash")
+	if err != nil {
+		t.Fatalf("could not retrieve link: %v", err)
+	}
+
+	destinationHits := int64(0)
+	for _, destination := range got.Destinations {
+		destinationHits += destination.Hits
+	}
+	if got.Hits != visits || destinationHits != visits {
+		t.Errorf("every visit should be counted on the link and its destination; want %d; got %d and %d",
+			visits, got.Hits, destinationHits)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	// Schedules can name any timezone, even on hosts without zoneinfo installed
+	_ "time/tzdata"
+
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// location returns the timezone a link's schedule is evaluated in
+func (app *app) location(link models.Link) *time.Location {
+	fallback, err := time.LoadLocation(app.currentConfig().Timezone)
+	if err != nil {
+		fallback = time.UTC
+	}
+	return link.Schedule.Location(fallback)
+}
+
+// scheduledLink returns link as it redirects at the given time, with its url replaced by that of
+// the schedule rule covering the time if there is one. Routed links can't have rules, so the kind
+// only ever changes between standard and formatted.
+func (app *app) scheduledLink(link models.Link, at time.Time) models.Link {
+	rule := link.Schedule.Match(at, app.location(link))
+	if rule < 0 {
+		return link
+	}
+
+	link.URL = link.Schedule.Rules[rule].URL
+	link.Kind = models.KindOf(link.URL)
+	return link
+}
+
+// schedulePreview is where a link would redirect at a given time
+type schedulePreview struct {
+	ID       string `json:"id"`
+	At       int64  `json:"at"`
+	Timezone string `json:"timezone"`
+	Active   bool   `json:"active"`
+	URL      string `json:"url,omitempty"`  // empty when the link isn't active
+	Rule     *int   `json:"rule,omitempty"` // index of the schedule rule that picked the url, if one did
+}
+
+// previewLinkHandler shows where a link would redirect at the time given by the at parameter,
+// or now when it isn't given
+func (app *app) previewLinkHandler(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	at := time.Now()
+	if value := req.URL.Query().Get("at"); value != "" {
+		var err error
+		at, err = parseTime(value)
+		if err != nil {
+			sendErrResponse(w, req, utilErrors.New(utilErrors.CodeInvalidRequest, err.Error()))
+			return
+		}
+	}
+
+	link, err := app.storage.GetLink(req.Context(), id)
+	if err != nil {
+		if errors.Is(err, utilErrors.ErrNotFound) {
+			sendErrResponse(w, req, utilErrors.New(utilErrors.CodeLinkNotFound, "link not found"))
+			return
+		}
+		log.Error().Err(err).Msg("error retrieving link")
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve link", err))
+		return
+	}
+
+	location := app.location(link)
+	preview := schedulePreview{
+		ID:       id,
+		At:       at.Unix(),
+		Timezone: location.String(),
+		Active:   link.Schedule.Active(at),
+	}
+
+	if preview.Active {
+		preview.URL = link.FailoverURL()
+		if rule := link.Schedule.Match(at, location); rule >= 0 {
+			preview.URL = link.Schedule.Rules[rule].URL
+			preview.Rule = &rule
+		}
+	}
+
+	sendResponse(w, http.StatusOK, preview)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/clintjedwards/goto/models"
+)
+
+func TestScheduledLinks(t *testing.T) {
+	app := newTestApp(t)
+	app.config.Load().Timezone = "Europe/London"
+	router, err := app.newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	links := []models.Link{
+		{ID: "launch", URL: "https://example.com/launch", Schedule: &models.Schedule{
+			ActiveFrom: time.Now().Add(time.Hour).Unix(),
+		}},
+		{ID: "standup", URL: "https://meet.example.com/default", Schedule: &models.Schedule{
+			Rules: []models.ScheduleRule{
+				{Days: []string{"mon"}, URL: "https://meet.example.com/room-a"},
+				{Days: []string{"tue"}, URL: "https://meet.example.com/room-b"},
+			},
+		}},
+	}
+	for _, link := range links {
+		err := app.storage.CreateLink(context.Background(), &link)
+		if err != nil {
+			t.Fatalf("could not create link: %v", err)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/launch", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("links should not redirect before they are active; got status %d", recorder.Code)
+	}
+
+	tests := map[string]struct {
+		at   string
+		want string
+		rule bool
+	}{
+		"monday":                   {"2024-03-04T12:00:00Z", "https://meet.example.com/room-a", true},
+		"tuesday in london":        {"2024-03-04T23:30:00-01:00", "https://meet.example.com/room-b", true},
+		"no rule falls back":       {"2024-03-06T12:00:00Z", "https://meet.example.com/default", false},
+		"tuesday as epoch seconds": {"1709632800", "https://meet.example.com/room-b", true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest("GET", apiVersionPath+"/links/standup/preview?at="+tc.at, nil))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("unexpected status; want %d; got %d", http.StatusOK, recorder.Code)
+			}
+
+			var preview schedulePreview
+			err := json.NewDecoder(recorder.Body).Decode(&preview)
+			if err != nil {
+				t.Fatalf("could not decode preview: %v", err)
+			}
+
+			if preview.URL != tc.want || (preview.Rule != nil) != tc.rule || preview.Timezone != "Europe/London" {
+				t.Errorf("unexpected preview; want %s; got %+v", tc.want, preview)
+			}
+		})
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/clintjedwards/goto/config"
+	"github.com/coreos/go-systemd/v22/activation"
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/rs/zerolog/log"
+)
+
+// listenerName identifies what a listening socket is used for, so that sockets handed over
+// from systemd or a previous process can be matched up with their purpose.
+type listenerName string
+
+const (
+	httpListener  listenerName = "http"
+	httpsListener listenerName = "https"
+	unixListener  listenerName = "unix"
+)
+
+// inheritedListenersEnv is set on a replacement process to the names and file descriptors
+// of the listening sockets it should take over from its parent. ex. "http:3,https:4"
+const inheritedListenersEnv = "GOTO_INHERITED_LISTENERS"
+
+// wantedListeners returns the address of every listener enabled by the given configuration
+func wantedListeners(config *config.Config) map[listenerName]string {
+	wanted := map[listenerName]string{httpListener: config.Host}
+
+	if config.TLS.Enabled() {
+		wanted[httpsListener] = config.TLS.Host
+	}
+
+	if config.UnixSocket != "" {
+		wanted[unixListener] = config.UnixSocket
+	}
+
+	return wanted
+}
+
+// listen returns a socket for every enabled listener. Sockets handed down by a previous process
+// are preferred, then sockets passed in by systemd socket activation; any listener not provided
+// by either is opened on its configured address.
+func listen(config *config.Config) (map[listenerName]net.Listener, error) {
+	provided, err := inheritedListeners()
+	if err != nil {
+		return nil, err
+	}
+
+	if provided == nil {
+		provided, err = activatedListeners()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listeners := map[listenerName]net.Listener{}
+
+	for name, address := range wantedListeners(config) {
+		listener, ok := provided[name]
+		if ok {
+			delete(provided, name)
+		} else {
+			listener, err = newListener(name, address)
+			if err != nil {
+				return nil, fmt.Errorf("could not listen for %s on %s: %w", name, address, err)
+			}
+		}
+
+		listeners[name] = listener
+	}
+
+	for name, listener := range provided {
+		log.Warn().Str("name", string(name)).Str("address", listener.Addr().String()).
+			Msg("ignoring socket that is not enabled in configuration")
+		listener.Close()
+	}
+
+	return listeners, nil
+}
+
+func newListener(name listenerName, address string) (net.Listener, error) {
+	if name != unixListener {
+		return net.Listen("tcp", address)
+	}
+
+	// Clean up a socket left behind by a previous run
+	info, err := os.Stat(address)
+	if err == nil && info.Mode()&os.ModeSocket != 0 {
+		err = os.Remove(address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", address)
+	if err != nil {
+		return nil, err
+	}
+
+	// The socket file must outlive this process so that it can be handed over on restart
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	return listener, nil
+}
+
+// inheritedListeners returns the sockets passed down by a previous process, or nil if there are none
+func inheritedListeners() (map[listenerName]net.Listener, error) {
+	inherited := os.Getenv(inheritedListenersEnv)
+	if inherited == "" {
+		return nil, nil
+	}
+	os.Unsetenv(inheritedListenersEnv)
+
+	listeners := map[listenerName]net.Listener{}
+
+	for _, entry := range strings.Split(inherited, ",") {
+		name, fdString, found := strings.Cut(entry, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid inherited listener %q", entry)
+		}
+
+		fd, err := strconv.Atoi(fdString)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inherited listener %q: %w", entry, err)
+		}
+
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not use inherited listener %q: %w", name, err)
+		}
+
+		log.Info().Str("name", name).Str("address", listener.Addr().String()).
+			Msg("took over listener from previous process")
+		listeners[listenerName(name)] = listener
+	}
+
+	return listeners, nil
+}
+
+// activatedListeners returns the sockets provided through systemd socket activation (LISTEN_FDS),
+// so that binding privileged ports is left to systemd
+func activatedListeners() (map[listenerName]net.Listener, error) {
+	named, err := activation.ListenersWithNames()
+	if err != nil {
+		return nil, fmt.Errorf("could not use systemd sockets: %w", err)
+	}
+
+	return matchActivatedListeners(named)
+}
+
+// matchActivatedListeners matches up systemd sockets with what they are used for by their
+// FileDescriptorName. A single socket with any other name, including none, is used for plain http.
+func matchActivatedListeners(named map[string][]net.Listener) (map[listenerName]net.Listener, error) {
+	listeners := map[listenerName]net.Listener{}
+
+	for name, sockets := range named {
+		if len(sockets) != 1 {
+			return nil, fmt.Errorf("expected a single systemd socket named %q; got %d", name, len(sockets))
+		}
+
+		switch listenerName(name) {
+		case httpListener, httpsListener, unixListener:
+			listeners[listenerName(name)] = sockets[0]
+		default:
+			if len(named) != 1 {
+				return nil, fmt.Errorf("systemd socket %q must be named http, https or unix "+
+					"with FileDescriptorName when more than one socket is used", name)
+			}
+			listeners[httpListener] = sockets[0]
+		}
+	}
+
+	for name, listener := range listeners {
+		log.Info().Str("name", string(name)).Str("address", listener.Addr().String()).
+			Msg("using socket from systemd")
+	}
+
+	return listeners, nil
+}
+
+// connListenerKey is the context key under which the listener that accepted a connection is stored
+type connListenerKey struct{}
+
+// withConnListener records which listener a connection arrived on. It is used as http.Server.ConnContext.
+func withConnListener(ctx context.Context, conn net.Conn) context.Context {
+	name := httpListener
+
+	if _, ok := conn.(*tls.Conn); ok {
+		name = httpsListener
+	} else if conn.LocalAddr().Network() == "unix" {
+		name = unixListener
+	}
+
+	return context.WithValue(ctx, connListenerKey{}, name)
+}
+
+// connListener returns the listener that accepted the connection a request arrived on
+func connListener(ctx context.Context) listenerName {
+	name, ok := ctx.Value(connListenerKey{}).(listenerName)
+	if !ok {
+		return httpListener
+	}
+
+	return name
+}
+
+// serve handles requests on the given listeners until a shutdown signal is received.
+// Connections accepted by the https listener are served with tlsConfig.
+//
+// SIGHUP reloads the configuration. SIGINT and SIGTERM stop the server gracefully. SIGUSR2 does the same but then hands the
+// listening sockets to a freshly started copy of the binary. Connections that arrive while the
+// replacement is starting wait in the socket's backlog instead of being refused, so a restart
+// does not cause failed redirects.
+func (app *app) serve(server *http.Server, listeners map[listenerName]net.Listener, tlsConfig *tls.Config) error {
+	serveErr := make(chan error, len(listeners))
+	for name, listener := range listeners {
+		if name == httpsListener {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+
+		go func(listener net.Listener) {
+			serveErr <- server.Serve(listener)
+		}(listener)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go app.runWatchdog(backgroundCtx)
+	go app.watchConfig(backgroundCtx)
+
+	notifySystemd(daemon.SdNotifyReady)
+
+	for {
+		select {
+		case err := <-serveErr:
+			return err
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				log.Info().Str("signal", sig.String()).Msg("received signal; reloading configuration")
+				err := app.reloadConfig()
+				if err != nil {
+					log.Error().Err(err).Msg("could not reload configuration; keeping previous")
+				}
+				continue
+			}
+
+			if sig != syscall.SIGUSR2 {
+				log.Info().Str("signal", sig.String()).Msg("received signal; shutting down")
+				notifySystemd(daemon.SdNotifyStopping)
+				stopBackground()
+				return app.shutdown(server)
+			}
+
+			// Sockets are duplicated before shutting down the server, which closes the originals
+			sockets, err := listenerFiles(listeners)
+			if err != nil {
+				log.Error().Err(err).Msg("could not hand over listeners; continuing to serve")
+				continue
+			}
+			defer func() {
+				for _, socket := range sockets {
+					socket.Close()
+				}
+			}()
+
+			log.Info().Str("signal", sig.String()).Msg("received signal; restarting")
+			notifySystemd(daemon.SdNotifyReloading)
+			stopBackground()
+
+			err = app.shutdown(server)
+			if err != nil {
+				return err
+			}
+
+			return startReplacement(sockets)
+		}
+	}
+}
+
+// shutdown stops accepting connections, waits for in-flight requests and pending hit count
+// updates to finish, and then closes the storage engine.
+func (app *app) shutdown(server *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), app.currentConfig().ShutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("could not finish in-flight requests")
+	}
+
+	err = app.hits.drain(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("could not finish pending hit count updates")
+	}
+
+	err = app.storage.Close()
+	if err != nil {
+		return fmt.Errorf("could not close storage: %w", err)
+	}
+
+	log.Info().Msg("shutdown complete")
+	return nil
+}
+
+// listenerFiles duplicates the file descriptor of every listener so they can be passed to another process
+func listenerFiles(listeners map[listenerName]net.Listener) (map[listenerName]*os.File, error) {
+	files := map[listenerName]*os.File{}
+
+	for name, listener := range listeners {
+		fileListener, ok := listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("listener %s does not support handover", name)
+		}
+
+		file, err := fileListener.File()
+		if err != nil {
+			return nil, err
+		}
+
+		files[name] = file
+	}
+
+	return files, nil
+}
+
+// startReplacement starts a new copy of the running binary which takes over the given listeners
+func startReplacement(listeners map[listenerName]*os.File) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for name := range listeners {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	inherited := []string{}
+	for _, name := range names {
+		// ExtraFiles start after stdin, stdout and stderr
+		inherited = append(inherited, fmt.Sprintf("%s:%d", name, 3+len(cmd.ExtraFiles)))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, listeners[listenerName(name)])
+	}
+	cmd.Env = append(replacementEnv(), inheritedListenersEnv+"="+strings.Join(inherited, ","))
+
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("could not start replacement process: %w", err)
+	}
+
+	// The replacement becomes the process systemd supervises; it reports READY=1 itself once serving
+	notifySystemd(fmt.Sprintf("MAINPID=%d", cmd.Process.Pid))
+
+	log.Info().Int("pid", cmd.Process.Pid).Msg("started replacement process")
+	return nil
+}
+
+// replacementEnv returns the current environment without variables that only apply to this process.
+// The systemd watchdog is tied to a specific pid which the replacement won't have, leaving it out
+// lets the replacement pick up the watchdog once systemd knows its new main pid.
+func replacementEnv() []string {
+	env := []string{}
+	for _, variable := range os.Environ() {
+		if strings.HasPrefix(variable, "WATCHDOG_PID=") {
+			continue
+		}
+		env = append(env, variable)
+	}
+
+	return env
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/clintjedwards/goto/config"
+)
+
+func newTestListener(t *testing.T) net.Listener {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	return listener
+}
+
+func TestMatchActivatedListeners(t *testing.T) {
+	httpSocket, httpsSocket, unixSocket := newTestListener(t), newTestListener(t), newTestListener(t)
+
+	tests := map[string]struct {
+		named       map[string][]net.Listener
+		want        map[listenerName]net.Listener
+		shouldError bool
+	}{
+		"named": {
+			named: map[string][]net.Listener{"http": {httpSocket}, "https": {httpsSocket}, "unix": {unixSocket}},
+			want:  map[listenerName]net.Listener{httpListener: httpSocket, httpsListener: httpsSocket, unixListener: unixSocket},
+		},
+		"single unnamed": {
+			named: map[string][]net.Listener{"LISTEN_FD_3": {httpSocket}},
+			want:  map[listenerName]net.Listener{httpListener: httpSocket},
+		},
+		"single with another name": {
+			named: map[string][]net.Listener{"goto.socket": {httpSocket}},
+			want:  map[listenerName]net.Listener{httpListener: httpSocket},
+		},
+		"name mismatch": {
+			named:       map[string][]net.Listener{"http": {httpSocket}, "secure": {httpsSocket}},
+			shouldError: true,
+		},
+		"repeated name": {
+			named:       map[string][]net.Listener{"http": {httpSocket, httpsSocket}},
+			shouldError: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := matchActivatedListeners(tc.named)
+			if tc.shouldError {
+				if err == nil {
+					t.Errorf("sockets should not be matched; got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not match sockets: %v", err)
+			}
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("unexpected listeners; want %v; got %v", tc.want, got)
+			}
+			for name, listener := range tc.want {
+				if got[name] != listener {
+					t.Errorf("unexpected %s listener; want %v; got %v", name, listener.Addr(), got[name])
+				}
+			}
+		})
+	}
+}
+
+func TestListenPrefersInheritedListeners(t *testing.T) {
+	inherited := newTestListener(t)
+	file, err := inherited.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("could not get listener file: %v", err)
+	}
+	defer file.Close()
+
+	t.Setenv(inheritedListenersEnv, fmt.Sprintf("http:%d", file.Fd()))
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+
+	listeners, err := listen(&config.Config{Host: "127.0.0.1:0", TLS: &config.TLSConfig{}})
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	for _, listener := range listeners {
+		defer listener.Close()
+	}
+
+	if got := listeners[httpListener].Addr().String(); got != inherited.Addr().String() {
+		t.Errorf("inherited listener should be used; want %s; got %s", inherited.Addr(), got)
+	}
+
+	// Taking the systemd sockets would have cleared the variable
+	if os.Getenv("LISTEN_FDS") == "" {
+		t.Errorf("systemd sockets should be left alone when listeners are inherited")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/clintjedwards/goto/config"
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultStaleAge is how long links must have gone unused to be reported when no age is
+	// given and no stale link policy is configured
+	defaultStaleAge = 180 * 24 * time.Hour
+	// staleWebhookTimeout is how long the stale link webhook has to respond
+	staleWebhookTimeout = 10 * time.Second
+)
+
+// staleLinks returns the links that haven't been used since cutoff, least recently used first
+func staleLinks(links map[string]models.Link, cutoff time.Time, includeArchived bool) []models.Link {
+	stale := []models.Link{}
+	for _, link := range links {
+		if link.LastUsed() >= cutoff.Unix() || (link.Archived != 0 && !includeArchived) {
+			continue
+		}
+		stale = append(stale, link)
+	}
+
+	sort.Slice(stale, func(i, j int) bool {
+		if stale[i].LastUsed() != stale[j].LastUsed() {
+			return stale[i].LastUsed() < stale[j].LastUsed()
+		}
+		return stale[i].ID < stale[j].ID
+	})
+
+	return stale
+}
+
+// staleReportHandler lists links that haven't been followed within older_than. Archived links
+// are left out unless include_archived is set.
+func (app *app) staleReportHandler(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	age := app.currentConfig().Stale.After
+	if age == 0 {
+		age = defaultStaleAge
+	}
+	if value := query.Get("older_than"); value != "" {
+		var err error
+		age, err = parseWindow(value)
+		if err != nil {
+			sendErrResponse(w, req, utilErrors.New(utilErrors.CodeInvalidRequest, err.Error()))
+			return
+		}
+	}
+
+	includeArchived := query.Get("include_archived") == "true"
+
+	links, err := app.storage.GetAllLinks(req.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("error retrieving links")
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve links", err))
+		return
+	}
+
+	cutoff := time.Now().Add(-age)
+	sendResponse(w, http.StatusOK, models.StaleReport{
+		Cutoff: cutoff.Unix(),
+		Links:  staleLinks(links, cutoff, includeArchived),
+	})
+}
+
+// unarchiveLinkHandler makes an archived link redirect again. Restoring a link counts as using
+// it, so that the stale link policy doesn't archive it again straight away. Links are checked
+// against the url policy again since it may have changed while they were archived.
+func (app *app) unarchiveLinkHandler(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	policy := app.urlPolicy()
+
+	var restored models.Link
+	err := app.storage.UpdateLink(req.Context(), id, func(link *models.Link) error {
+		var violation *models.PolicyViolation
+		if errors.As(policy.CheckLink(*link), &violation) {
+			return utilErrors.New(utilErrors.CodeURLNotAllowed, "link can't be restored; "+violation.Message)
+		}
+
+		link.Archived = 0
+		link.LastAccessed = time.Now().Unix()
+		restored = *link
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, utilErrors.ErrNotFound) {
+			sendErrResponse(w, req, utilErrors.New(utilErrors.CodeLinkNotFound, "link not found"))
+			return
+		}
+		var apiErr *utilErrors.APIError
+		if errors.As(err, &apiErr) && apiErr.Code == utilErrors.CodeURLNotAllowed {
+			sendErrResponse(w, req, apiErr)
+			return
+		}
+		log.Error().Err(err).Msg("could not unarchive link")
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not unarchive link", err))
+		return
+	}
+
+	log.Info().Str("id", id).Msg("unarchived link")
+	sendResponse(w, http.StatusOK, restored)
+}
+
+// runStalePolicy looks for stale links on every interval and acts on them as configured. The
+// settings are read on every interval so that reloading the configuration changes them.
+// It blocks forever and should be run in a goroutine.
+func (app *app) runStalePolicy() {
+	config := app.currentConfig().Stale
+	log.Info().Dur("after", config.After).Str("action", config.Action).
+		Dur("interval", config.CheckInterval).Msg("stale link policy enabled")
+
+	interval := config.CheckInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		config := app.currentConfig().Stale
+		if config.CheckInterval > 0 && config.CheckInterval != interval {
+			interval = config.CheckInterval
+			ticker.Reset(interval)
+		}
+
+		// The policy may have been turned off since starting
+		if config.After <= 0 {
+			continue
+		}
+
+		err := app.enforceStalePolicy(context.Background(), config, time.Now())
+		if err != nil {
+			log.Error().Err(err).Msg("could not enforce stale link policy")
+		}
+	}
+}
+
+// enforceStalePolicy archives links that haven't been used within the configured period, if
+// configured to, and sends the links found to the webhook. Links already archived are skipped.
+func (app *app) enforceStalePolicy(ctx context.Context, config *config.StaleConfig, now time.Time) error {
+	links, err := app.storage.GetAllLinks(ctx)
+	if err != nil {
+		return err
+	}
+
+	cutoff := now.Add(-config.After)
+	stale := staleLinks(links, cutoff, false)
+	if len(stale) == 0 {
+		return nil
+	}
+
+	if config.Action == "archive" {
+		for i := range stale {
+			err := app.storage.UpdateLink(ctx, stale[i].ID, func(link *models.Link) error {
+				// The link may have been followed since it was listed
+				if link.LastUsed() < cutoff.Unix() && link.Archived == 0 {
+					link.Archived = now.Unix()
+				}
+				stale[i] = *link
+				return nil
+			})
+			if err != nil && !errors.Is(err, utilErrors.ErrNotFound) {
+				return fmt.Errorf("could not archive link %s: %w", stale[i].ID, err)
+			}
+		}
+	}
+
+	ids := []string{}
+	for _, link := range stale {
+		ids = append(ids, link.ID)
+	}
+	log.Info().Strs("ids", ids).Str("action", config.Action).Msg("found stale links")
+
+	if config.WebhookURL == "" {
+		return nil
+	}
+
+	return notifyStale(ctx, config.WebhookURL, models.StaleReport{
+		Cutoff: cutoff.Unix(),
+		Action: config.Action,
+		Links:  stale,
+	})
+}
+
+// notifyStale posts a stale link report to the webhook, so that the owners of links can be
+// told through whatever system the webhook feeds into
+func notifyStale(ctx context.Context, url string, report models.StaleReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, staleWebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send stale links to webhook: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("stale link webhook returned %s", response.Status)
+	}
+
+	return nil
+}
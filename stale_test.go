@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/clintjedwards/goto/config"
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+)
+
+func TestEnforceStalePolicy(t *testing.T) {
+	app := newTestApp(t)
+	router, err := app.newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	now := time.Now()
+	year := 365 * 24 * time.Hour
+	links := []models.Link{
+		{ID: "unused", URL: "https://example.com", Created: now.Add(-2 * year).Unix()},
+		{ID: "forgotten", URL: "https://example.com", Created: now.Add(-3 * year).Unix(), LastAccessed: now.Add(-2 * year).Unix()},
+		{ID: "popular", URL: "https://example.com", Created: now.Add(-3 * year).Unix(), LastAccessed: now.Add(-time.Hour).Unix()},
+		{ID: "new", URL: "https://example.com", Created: now.Add(-time.Hour).Unix()},
+	}
+	for _, link := range links {
+		link := link
+		if err := app.storage.CreateLink(context.Background(), &link); err != nil {
+			t.Fatalf("could not create link: %v", err)
+		}
+	}
+
+	var report models.StaleReport
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := json.NewDecoder(req.Body).Decode(&report); err != nil {
+			t.Errorf("could not decode webhook body: %v", err)
+		}
+	}))
+	defer webhook.Close()
+
+	err = app.enforceStalePolicy(context.Background(), &config.StaleConfig{
+		After:      year,
+		Action:     "archive",
+		WebhookURL: webhook.URL,
+	}, now)
+	if err != nil {
+		t.Fatalf("could not enforce stale policy: %v", err)
+	}
+
+	if len(report.Links) != 2 || report.Links[0].ID != "forgotten" || report.Links[1].ID != "unused" {
+		t.Fatalf("webhook should be sent the stale links least recently used first; got %+v", report.Links)
+	}
+	for _, link := range report.Links {
+		if link.Archived != now.Unix() {
+			t.Errorf("stale link %s should be archived; got %d", link.ID, link.Archived)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/unused", nil))
+	if recorder.Code != http.StatusGone {
+		t.Errorf("archived link should be gone; got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/popular", nil))
+	if recorder.Code != http.StatusMovedPermanently {
+		t.Errorf("recently used link should still redirect; got %d", recorder.Code)
+	}
+
+	// Archived links are left out of later checks until they're restored
+	report = models.StaleReport{}
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", apiVersionPath+"/links/unused/unarchive", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("could not unarchive link; got %d", recorder.Code)
+	}
+
+	err = app.enforceStalePolicy(context.Background(), &config.StaleConfig{
+		After:      year,
+		Action:     "archive",
+		WebhookURL: webhook.URL,
+	}, now)
+	if err != nil {
+		t.Fatalf("could not enforce stale policy: %v", err)
+	}
+	if len(report.Links) != 0 {
+		t.Errorf("archived and restored links should not be reported again; got %+v", report.Links)
+	}
+}
+
+func TestUnarchiveChecksPolicy(t *testing.T) {
+	app := newTestApp(t)
+	router, err := app.newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	// Links archived before the policy denied their destination can't be brought back
+	err = app.storage.CreateLink(context.Background(), &models.Link{ID: "paste", URL: "https://pastebin.com/abc", Archived: 1})
+	if err != nil {
+		t.Fatalf("could not create link: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", apiVersionPath+"/links/paste/unarchive", nil))
+
+	var body struct {
+		Error utilErrors.APIError `json:"error"`
+	}
+	_ = json.NewDecoder(recorder.Body).Decode(&body)
+	if recorder.Code != http.StatusBadRequest || body.Error.Code != utilErrors.CodeURLNotAllowed {
+		t.Errorf("unexpected response; want %d with url_not_allowed; got %d with %q", http.StatusBadRequest, recorder.Code, body.Error.Code)
+	}
+
+	link, err := app.storage.GetLink(context.Background(), "paste")
+	if err != nil {
+		t.Fatalf("could not retrieve link: %v", err)
+	}
+	if link.Archived == 0 {
+		t.Errorf("link should stay archived")
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+	"github.com/clintjedwards/goto/storage"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxStatsBuckets limits how many buckets a single time series request can cover
+	maxStatsBuckets = 1000
+	// defaultTopLimit is how many links are returned by a top links request by default
+	defaultTopLimit = 10
+	// maxTopLimit is the most links a top links request may ask for
+	maxTopLimit = 1000
+)
+
+// linkStatsHandler returns a time series of hits for a single link
+func (app *app) linkStatsHandler(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	_, err := app.storage.GetLink(req.Context(), id)
+	if err != nil {
+		if errors.Is(err, utilErrors.ErrNotFound) {
+			sendErrResponse(w, req, utilErrors.New(utilErrors.CodeLinkNotFound, "link not found"))
+			return
+		}
+		log.Error().Err(err).Msg("error retrieving link")
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve link", err))
+		return
+	}
+
+	app.sendStats(w, req, id)
+}
+
+// globalStatsHandler returns a time series of hits summed across all links
+func (app *app) globalStatsHandler(w http.ResponseWriter, req *http.Request) {
+	app.sendStats(w, req, "")
+}
+
+func (app *app) sendStats(w http.ResponseWriter, req *http.Request, id string) {
+	granularity, from, to, err := parseStatsRange(req.URL.Query(), time.Now())
+	if err != nil {
+		sendErrResponse(w, req, utilErrors.New(utilErrors.CodeInvalidRequest, err.Error()))
+		return
+	}
+
+	hits, err := app.storage.GetHits(req.Context(), id, granularity, from, to)
+	if err != nil {
+		log.Error().Err(err).Str("id", id).Msg("error retrieving hits")
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve hits", err))
+		return
+	}
+
+	sendResponse(w, http.StatusOK, timeSeries(id, granularity, from, to, hits))
+}
+
+// timeSeries fills in the buckets between from and to that had no hits
+func timeSeries(id string, granularity models.Granularity, from, to time.Time, hits []models.HitBucket) models.LinkStats {
+	counts := map[int64]int64{}
+	for _, bucket := range hits {
+		counts[bucket.Start] = bucket.Hits
+	}
+
+	stats := models.LinkStats{
+		ID:          id,
+		Granularity: granularity,
+		From:        from.Unix(),
+		To:          to.Unix(),
+		Buckets:     []models.HitBucket{},
+	}
+
+	for start := from; start.Before(to); start = start.Add(granularity.Duration()) {
+		count := counts[start.Unix()]
+		stats.Total += count
+		stats.Buckets = append(stats.Buckets, models.HitBucket{Start: start.Unix(), Hits: count})
+	}
+
+	return stats
+}
+
+// parseStatsRange reads the granularity and time range of a time series request. The range is
+// widened to whole buckets and defaults to the week, or day for hourly buckets, ending now.
+func parseStatsRange(query url.Values, now time.Time) (models.Granularity, time.Time, time.Time, error) {
+	granularity := models.Daily
+	if value := query.Get("granularity"); value != "" {
+		var err error
+		granularity, err = models.ParseGranularity(value)
+		if err != nil {
+			return "", time.Time{}, time.Time{}, err
+		}
+	}
+
+	to := now
+	if value := query.Get("to"); value != "" {
+		var err error
+		to, err = parseTime(value)
+		if err != nil {
+			return "", time.Time{}, time.Time{}, fmt.Errorf("to: %w", err)
+		}
+	}
+
+	from := to.Add(-7 * 24 * time.Hour)
+	if granularity == models.Hourly {
+		from = to.Add(-24 * time.Hour)
+	}
+	if value := query.Get("from"); value != "" {
+		var err error
+		from, err = parseTime(value)
+		if err != nil {
+			return "", time.Time{}, time.Time{}, fmt.Errorf("from: %w", err)
+		}
+	}
+
+	from = granularity.BucketStart(from)
+	if end := granularity.BucketStart(to); !end.Equal(to) {
+		to = end.Add(granularity.Duration())
+	}
+
+	if !from.Before(to) {
+		return "", time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+
+	if to.Sub(from)/granularity.Duration() > maxStatsBuckets {
+		return "", time.Time{}, time.Time{}, fmt.Errorf("range covers more than %d %s buckets", maxStatsBuckets, granularity)
+	}
+
+	return granularity, from, to, nil
+}
+
+// parseTime accepts an RFC 3339 timestamp, a date or epoch seconds
+func parseTime(value string) (time.Time, error) {
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0).UTC(), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		parsed, err := time.Parse(layout, value)
+		if err == nil {
+			return parsed, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%q must be an RFC 3339 time, a date (2006-01-02) or epoch seconds", value)
+}
+
+// parseWindow reads a length of time such as 7d, 12h or 90m
+func parseWindow(value string) (time.Duration, error) {
+	var window time.Duration
+	var err error
+
+	if days, found := strings.CutSuffix(value, "d"); found {
+		var count int
+		count, err = strconv.Atoi(days)
+		window = time.Duration(count) * 24 * time.Hour
+	} else {
+		window, err = time.ParseDuration(value)
+	}
+
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("window %q must be a positive length of time such as 7d or 12h", value)
+	}
+
+	return window, nil
+}
+
+// topLinksHandler returns links ordered by how often they were followed within a recent window.
+// Ascending order puts links that haven't been used at all first.
+func (app *app) topLinksHandler(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	window := 7 * 24 * time.Hour
+	if value := query.Get("window"); value != "" {
+		var err error
+		window, err = parseWindow(value)
+		if err != nil {
+			sendErrResponse(w, req, utilErrors.New(utilErrors.CodeInvalidRequest, err.Error()))
+			return
+		}
+	}
+
+	limit := defaultTopLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxTopLimit {
+			sendErrResponse(w, req, utilErrors.New(utilErrors.CodeInvalidRequest,
+				fmt.Sprintf("limit must be between 1 and %d", maxTopLimit)))
+			return
+		}
+	}
+
+	ascending := false
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		ascending = true
+	default:
+		sendErrResponse(w, req, utilErrors.New(utilErrors.CodeInvalidRequest, "order must be asc or desc"))
+		return
+	}
+
+	// Hourly buckets are more precise but aren't kept for long windows
+	granularity := models.Hourly
+	if window > storage.HourlyHitRetention {
+		granularity = models.Daily
+	}
+
+	to := time.Now()
+	from := granularity.BucketStart(to.Add(-window))
+
+	totals, err := app.storage.GetHitTotals(req.Context(), granularity, from, to)
+	if err != nil {
+		log.Error().Err(err).Msg("error retrieving hit totals")
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve hits", err))
+		return
+	}
+
+	links, err := app.storage.GetAllLinks(req.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("error retrieving links")
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve links", err))
+		return
+	}
+
+	sendResponse(w, http.StatusOK, map[string]interface{}{
+		"from":  from.Unix(),
+		"to":    to.Unix(),
+		"links": rankLinks(links, totals, ascending, limit),
+	})
+}
+
+// rankLinks orders every link by its hits, including links without any, and keeps the first limit
+func rankLinks(links map[string]models.Link, totals map[string]int64, ascending bool, limit int) []models.LinkHits {
+	ranked := []models.LinkHits{}
+	for id := range links {
+		ranked = append(ranked, models.LinkHits{ID: id, Hits: totals[id]})
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Hits != ranked[j].Hits {
+			return (ranked[i].Hits < ranked[j].Hits) == ascending
+		}
+		return ranked[i].ID < ranked[j].ID
+	})
+
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	return ranked
+}
@@ -1,7 +1,16 @@
 package bolt
 
 import (
+	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -26,13 +35,35 @@ func Init(config *config.BoltConfig) (Bolt, error) {
 		return Bolt{}, err
 	}
 
-	// Create root bucket if not exists
+	// Create root buckets if not exists
 	err = store.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte(storage.LinksBucket))
 		if err != nil {
 			return err
 		}
 
+		hitsBucket, err := tx.CreateBucketIfNotExists([]byte(storage.HitsBucket))
+		if err != nil {
+			return err
+		}
+
+		for _, granularity := range models.Granularities {
+			_, err := hitsBucket.CreateBucketIfNotExists([]byte(granularity))
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(storage.VisitsBucket))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(storage.TagsBucket))
+		if err != nil {
+			return err
+		}
+
 		return nil
 	})
 	if err != nil {
@@ -46,7 +77,7 @@ func Init(config *config.BoltConfig) (Bolt, error) {
 }
 
 // GetLink returns a link by short name
-func (db *Bolt) GetLink(id string) (models.Link, error) {
+func (db *Bolt) GetLink(_ context.Context, id string) (models.Link, error) {
 
 	storedLink := models.Link{}
 
@@ -73,7 +104,7 @@ func (db *Bolt) GetLink(id string) (models.Link, error) {
 }
 
 // GetAllLinks returns an unpaginated list of current links
-func (db *Bolt) GetAllLinks() (map[string]models.Link, error) {
+func (db *Bolt) GetAllLinks(_ context.Context) (map[string]models.Link, error) {
 
 	results := map[string]models.Link{}
 
@@ -100,8 +131,102 @@ func (db *Bolt) GetAllLinks() (map[string]models.Link, error) {
 	return results, nil
 }
 
+// GetLinksByTag returns the links tagged with tag
+func (db *Bolt) GetLinksByTag(_ context.Context, tag string) (map[string]models.Link, error) {
+	results := map[string]models.Link{}
+
+	err := db.store.View(func(tx *bolt.Tx) error {
+		tagBucket := tx.Bucket([]byte(storage.TagsBucket)).Bucket([]byte(tag))
+		if tagBucket == nil {
+			return nil
+		}
+
+		linksBucket := tx.Bucket([]byte(storage.LinksBucket))
+
+		return tagBucket.ForEach(func(key, _ []byte) error {
+			linkRaw := linksBucket.Get(key)
+			if linkRaw == nil {
+				return nil
+			}
+
+			var link models.Link
+			err := json.Unmarshal(linkRaw, &link)
+			if err != nil {
+				return err
+			}
+
+			results[string(key)] = link
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// GetTags returns the number of links with each tag in use
+func (db *Bolt) GetTags(_ context.Context) (map[string]int64, error) {
+	tags := map[string]int64{}
+
+	err := db.store.View(func(tx *bolt.Tx) error {
+		tagsBucket := tx.Bucket([]byte(storage.TagsBucket))
+
+		return tagsBucket.ForEach(func(tag, _ []byte) error {
+			tags[string(tag)] = int64(tagsBucket.Bucket(tag).Stats().KeyN)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// indexTags updates the tag index for a link whose tags changed from current to updated. Each tag
+// has a bucket keyed by the ids of the links with it, which is removed once no links have the tag.
+func indexTags(tx *bolt.Tx, id string, current, updated []string) error {
+	tagsBucket := tx.Bucket([]byte(storage.TagsBucket))
+	added, removed := storage.TagChanges(current, updated)
+
+	for _, tag := range added {
+		tagBucket, err := tagsBucket.CreateBucketIfNotExists([]byte(tag))
+		if err != nil {
+			return err
+		}
+
+		err = tagBucket.Put([]byte(id), []byte{})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, tag := range removed {
+		tagBucket := tagsBucket.Bucket([]byte(tag))
+		if tagBucket == nil {
+			continue
+		}
+
+		err := tagBucket.Delete([]byte(id))
+		if err != nil {
+			return err
+		}
+
+		if key, _ := tagBucket.Cursor().First(); key == nil {
+			err = tagsBucket.DeleteBucket([]byte(tag))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // CreateLink stores a new link into database
-func (db *Bolt) CreateLink(link *models.Link) error {
+func (db *Bolt) CreateLink(_ context.Context, link *models.Link) error {
 	err := db.store.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(storage.LinksBucket))
 
@@ -120,7 +245,7 @@ func (db *Bolt) CreateLink(link *models.Link) error {
 			return err
 		}
 
-		return nil
+		return indexTags(tx, link.ID, nil, link.Tags)
 	})
 	if err != nil {
 		return err
@@ -129,8 +254,45 @@ func (db *Bolt) CreateLink(link *models.Link) error {
 	return nil
 }
 
-// BumpHitCount updates the hit number on a certain link
-func (db *Bolt) BumpHitCount(id string) error {
+// UpdateLink applies update to a stored link within a single transaction
+func (db *Bolt) UpdateLink(_ context.Context, id string, update func(link *models.Link) error) error {
+	return db.store.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(storage.LinksBucket))
+
+		linkRaw := bucket.Get([]byte(id))
+		if linkRaw == nil {
+			return utilErrors.ErrNotFound
+		}
+
+		storedLink := models.Link{}
+		err := json.Unmarshal(linkRaw, &storedLink)
+		if err != nil {
+			return err
+		}
+
+		currentTags := slices.Clone(storedLink.Tags)
+
+		err = update(&storedLink)
+		if err != nil {
+			return err
+		}
+
+		encodedLink, err := json.Marshal(storedLink)
+		if err != nil {
+			return err
+		}
+
+		err = bucket.Put([]byte(id), encodedLink)
+		if err != nil {
+			return err
+		}
+
+		return indexTags(tx, id, currentTags, storedLink.Tags)
+	})
+}
+
+// BumpHitCount updates the hit number on a certain link and records the hit in its time series
+func (db *Bolt) BumpHitCount(_ context.Context, id string, at time.Time) error {
 	storedLink := models.Link{}
 
 	err := db.store.Update(func(tx *bolt.Tx) error {
@@ -147,6 +309,9 @@ func (db *Bolt) BumpHitCount(id string) error {
 		}
 
 		storedLink.Hits++
+		if at.Unix() > storedLink.LastAccessed {
+			storedLink.LastAccessed = at.Unix()
+		}
 
 		encodedLink, err := json.Marshal(storedLink)
 		if err != nil {
@@ -158,7 +323,7 @@ func (db *Bolt) BumpHitCount(id string) error {
 			return err
 		}
 
-		return nil
+		return recordHit(tx, id, at)
 	})
 	if err != nil {
 		return err
@@ -167,21 +332,451 @@ func (db *Bolt) BumpHitCount(id string) error {
 	return nil
 }
 
-// DeleteLink removes a link from the database
-func (db *Bolt) DeleteLink(id string) error {
+// recordHit increments the hit buckets of the given link that cover at. Each granularity
+// holds a bucket per link, keyed by the time each bucket of hits starts.
+func recordHit(tx *bolt.Tx, id string, at time.Time) error {
+	hitsBucket := tx.Bucket([]byte(storage.HitsBucket))
+
+	for _, granularity := range models.Granularities {
+		linkBucket, err := hitsBucket.Bucket([]byte(granularity)).CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+
+		key := encodeTime(granularity.BucketStart(at))
+		err = linkBucket.Put(key, encodeCount(decodeCount(linkBucket.Get(key))+1))
+		if err != nil {
+			return err
+		}
+
+		if granularity == models.Hourly {
+			err = pruneHits(linkBucket, at.Add(-storage.HourlyHitRetention))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// pruneHits removes hit buckets that start before cutoff
+func pruneHits(linkBucket *bolt.Bucket, cutoff time.Time) error {
+	expired := [][]byte{}
+
+	cursor := linkBucket.Cursor()
+	for key, _ := cursor.First(); key != nil && decodeTime(key).Before(cutoff); key, _ = cursor.Next() {
+		expired = append(expired, key)
+	}
+
+	for _, key := range expired {
+		err := linkBucket.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetHits returns a link's hits in order, or the hits of all links when id is empty
+func (db *Bolt) GetHits(_ context.Context, id string, granularity models.Granularity,
+	from, to time.Time) ([]models.HitBucket, error) {
+	hits := map[int64]int64{}
+
+	err := db.store.View(func(tx *bolt.Tx) error {
+		granularityBucket := tx.Bucket([]byte(storage.HitsBucket)).Bucket([]byte(granularity))
+
+		if id != "" {
+			linkBucket := granularityBucket.Bucket([]byte(id))
+			if linkBucket == nil {
+				return nil
+			}
+			sumHits(linkBucket, from, to, hits)
+			return nil
+		}
+
+		return granularityBucket.ForEach(func(key, _ []byte) error {
+			sumHits(granularityBucket.Bucket(key), from, to, hits)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := []models.HitBucket{}
+	for start, count := range hits {
+		buckets = append(buckets, models.HitBucket{Start: start, Hits: count})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start < buckets[j].Start })
+
+	return buckets, nil
+}
+
+// GetHitTotals returns the number of hits each link received within [from, to)
+func (db *Bolt) GetHitTotals(_ context.Context, granularity models.Granularity,
+	from, to time.Time) (map[string]int64, error) {
+	totals := map[string]int64{}
+
+	err := db.store.View(func(tx *bolt.Tx) error {
+		granularityBucket := tx.Bucket([]byte(storage.HitsBucket)).Bucket([]byte(granularity))
+
+		return granularityBucket.ForEach(func(key, _ []byte) error {
+			hits := map[int64]int64{}
+			sumHits(granularityBucket.Bucket(key), from, to, hits)
+
+			for _, count := range hits {
+				totals[string(key)] += count
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return totals, nil
+}
+
+// sumHits adds the counts of every bucket starting within [from, to) to hits, keyed by start
+func sumHits(linkBucket *bolt.Bucket, from, to time.Time, hits map[int64]int64) {
+	if linkBucket == nil {
+		return
+	}
+
+	cursor := linkBucket.Cursor()
+	for key, value := cursor.Seek(encodeTime(from)); key != nil && decodeTime(key).Before(to); key, value = cursor.Next() {
+		hits[decodeTime(key).Unix()] += decodeCount(value)
+	}
+}
+
+// encodeTime encodes t so that keys sort in time order
+func encodeTime(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.Unix()))
+	return key
+}
+
+func decodeTime(key []byte) time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint64(key)), 0).UTC()
+}
+
+func encodeCount(count int64) []byte {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(count))
+	return value
+}
+
+func decodeCount(value []byte) int64 {
+	if len(value) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(value))
+}
+
+// RecordVisit counts each collected attribute of a visit. Each link has a bucket per dimension,
+// keyed by value.
+func (db *Bolt) RecordVisit(_ context.Context, id string, visit models.Visit) error {
+	return db.store.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(storage.LinksBucket)).Get([]byte(id)) == nil {
+			return utilErrors.ErrNotFound
+		}
+
+		linkBucket, err := tx.Bucket([]byte(storage.VisitsBucket)).CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+
+		for dimension, value := range visit.Values() {
+			dimensionBucket, err := linkBucket.CreateBucketIfNotExists([]byte(dimension))
+			if err != nil {
+				return err
+			}
+
+			key := []byte(value)
+			count := dimensionBucket.Get(key)
+			if count == nil && dimensionBucket.Stats().KeyN >= storage.MaxVisitValues {
+				key = []byte(storage.OtherVisitValue)
+				count = dimensionBucket.Get(key)
+			}
+
+			err = dimensionBucket.Put(key, encodeCount(decodeCount(count)+1))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetVisits returns the number of visits to a link for each value of each dimension
+func (db *Bolt) GetVisits(_ context.Context, id string) (map[models.VisitDimension]map[string]int64, error) {
+	visits := map[models.VisitDimension]map[string]int64{}
+	for _, dimension := range models.VisitDimensions {
+		visits[dimension] = map[string]int64{}
+	}
+
+	err := db.store.View(func(tx *bolt.Tx) error {
+		linkBucket := tx.Bucket([]byte(storage.VisitsBucket)).Bucket([]byte(id))
+		if linkBucket == nil {
+			return nil
+		}
+
+		for _, dimension := range models.VisitDimensions {
+			dimensionBucket := linkBucket.Bucket([]byte(dimension))
+			if dimensionBucket == nil {
+				continue
+			}
+
+			err := dimensionBucket.ForEach(func(key, value []byte) error {
+				visits[dimension][string(key)] = decodeCount(value)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return visits, nil
+}
+
+// DeleteLink removes a link, its hits, its visits and its tags from the database
+func (db *Bolt) DeleteLink(_ context.Context, id string) error {
 	err := db.store.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(storage.LinksBucket))
 
+		if linkRaw := bucket.Get([]byte(id)); linkRaw != nil {
+			var storedLink models.Link
+			err := json.Unmarshal(linkRaw, &storedLink)
+			if err != nil {
+				return err
+			}
+
+			err = indexTags(tx, id, storedLink.Tags, nil)
+			if err != nil {
+				return err
+			}
+		}
+
 		err := bucket.Delete([]byte(id))
 		if err != nil {
 			return err
 		}
 
+		hitsBucket := tx.Bucket([]byte(storage.HitsBucket))
+		for _, granularity := range models.Granularities {
+			err := hitsBucket.Bucket([]byte(granularity)).DeleteBucket([]byte(id))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+
+		err = tx.Bucket([]byte(storage.VisitsBucket)).DeleteBucket([]byte(id))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Ping makes sure the database file is still present and readable
+func (db *Bolt) Ping(_ context.Context) error {
+	_, err := os.Stat(db.store.Path())
+	if err != nil {
+		return err
+	}
+
+	return db.store.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(storage.LinksBucket)) == nil {
+			return fmt.Errorf("%q bucket missing", storage.LinksBucket)
+		}
 		return nil
 	})
+}
+
+// Close releases the database file so that other processes may open it
+func (db *Bolt) Close() error {
+	return db.store.Close()
+}
+
+// Snapshot writes a consistent copy of the entire database to w using a read transaction,
+// so links can still be followed and created while the snapshot is in progress.
+func (db *Bolt) Snapshot(_ context.Context, w io.Writer) (int64, error) {
+	var size int64
+
+	err := db.store.View(func(tx *bolt.Tx) error {
+		var err error
+		size, err = tx.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// ValidateSnapshot opens a snapshot file read-only and makes sure that it contains a links bucket
+// in which every link can be decoded. It returns the number of links found.
+func ValidateSnapshot(path string) (int, error) {
+	store, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return 0, fmt.Errorf("could not open snapshot: %w", err)
+	}
+	defer store.Close()
+
+	count := 0
+
+	err = store.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(storage.LinksBucket))
+		if bucket == nil {
+			return fmt.Errorf("snapshot is missing %q bucket", storage.LinksBucket)
+		}
+
+		return bucket.ForEach(func(key, value []byte) error {
+			var link models.Link
+
+			err := json.Unmarshal(value, &link)
+			if err != nil {
+				return fmt.Errorf("could not decode link %q: %w", key, err)
+			}
+
+			if link.ID != string(key) {
+				return fmt.Errorf("link stored under %q has mismatched id %q", key, link.ID)
+			}
+
+			count++
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Restore validates the given snapshot and swaps it in place of the database at config.Path.
+// The database must not be in use; the previous database file is kept alongside it with the time
+// of the restore and a ".bak" suffix, so that earlier copies aren't overwritten.
+func Restore(config *config.BoltConfig, snapshotPath string) error {
+	count, err := ValidateSnapshot(snapshotPath)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+
+	_, err = os.Stat(config.Path)
+	existing := err == nil
+
+	// Opening the current database ensures nothing else holds it while we swap it out
+	if existing {
+		store, err := bolt.Open(config.Path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+		if err != nil {
+			return fmt.Errorf("database %s is in use; stop the server before restoring: %w", config.Path, err)
+		}
+		store.Close()
+	}
+
+	tmpPath := config.Path + ".restore"
+
+	err = copyFile(snapshotPath, tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if existing {
+		previousPath, err := backupPath(config.Path, time.Now())
+		if err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+
+		err = os.Rename(config.Path, previousPath)
+		if err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+		log.Info().Str("path", previousPath).Msg("kept previous bolt db")
+	}
+
+	err = os.Rename(tmpPath, config.Path)
 	if err != nil {
 		return err
 	}
 
+	log.Info().Str("path", config.Path).Str("snapshot", snapshotPath).Int("links", count).
+		Msg("restored bolt db from snapshot")
+
 	return nil
 }
+
+// backupPath returns an unused name to keep the database at path under, ex. goto.db.20240304T093000.bak.
+// A counter is added for restores within the same second.
+func backupPath(path string, at time.Time) (string, error) {
+	stamp := at.UTC().Format("20060102T150405")
+
+	for attempt := 0; attempt < 100; attempt++ {
+		candidate := fmt.Sprintf("%s.%s.bak", path, stamp)
+		if attempt > 0 {
+			candidate = fmt.Sprintf("%s.%s-%d.bak", path, stamp, attempt)
+		}
+
+		_, err := os.Stat(candidate)
+		if errors.Is(err, os.ErrNotExist) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("could not find an unused name to keep %s under", path)
+}
+
+// copyFile copies src to dst, making sure the contents are flushed to disk
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	err = os.MkdirAll(filepath.Dir(dst), 0700)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	err = out.Sync()
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
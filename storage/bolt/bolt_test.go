@@ -0,0 +1,259 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/clintjedwards/goto/config"
+	"github.com/clintjedwards/goto/models"
+	"github.com/clintjedwards/goto/storage"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := Init(&config.BoltConfig{Path: filepath.Join(dir, "src.db")})
+	if err != nil {
+		t.Fatalf("could not init bolt db: %v", err)
+	}
+	defer db.store.Close()
+
+	want := models.Link{ID: "github", URL: "https://github.com", Created: 1580000000, Hits: 3, Kind: models.Standard}
+	if err := db.CreateLink(context.Background(), &want); err != nil {
+		t.Fatalf("could not create link: %v", err)
+	}
+
+	snapshot := bytes.Buffer{}
+	if _, err := db.Snapshot(context.Background(), &snapshot); err != nil {
+		t.Fatalf("could not take snapshot: %v", err)
+	}
+
+	snapshotPath := filepath.Join(dir, "snapshot.db")
+	if err := os.WriteFile(snapshotPath, snapshot.Bytes(), 0600); err != nil {
+		t.Fatalf("could not write snapshot: %v", err)
+	}
+
+	count, err := ValidateSnapshot(snapshotPath)
+	if err != nil {
+		t.Fatalf("snapshot should be valid: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("snapshot link count mismatch; want 1; got %d", count)
+	}
+
+	// The database file being restored over must not be in use
+	if err := Restore(&config.BoltConfig{Path: filepath.Join(dir, "src.db")}, snapshotPath); err == nil {
+		t.Errorf("restore should fail while database is open")
+	}
+
+	restoredPath := filepath.Join(dir, "restored.db")
+	if err := Restore(&config.BoltConfig{Path: restoredPath}, snapshotPath); err != nil {
+		t.Fatalf("could not restore snapshot: %v", err)
+	}
+
+	restored, err := Init(&config.BoltConfig{Path: restoredPath})
+	if err != nil {
+		t.Fatalf("could not open restored db: %v", err)
+	}
+	defer restored.store.Close()
+
+	got, err := restored.GetLink(context.Background(), "github")
+	if err != nil {
+		t.Fatalf("could not retrieve restored link: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restored link mismatch; want %+v; got %+v", want, got)
+	}
+}
+
+func TestRestoreKeepsPreviousDatabases(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := Init(&config.BoltConfig{Path: filepath.Join(dir, "src.db")})
+	if err != nil {
+		t.Fatalf("could not init bolt db: %v", err)
+	}
+	snapshotPath := filepath.Join(dir, "snapshot.db")
+	snapshot, err := os.Create(snapshotPath)
+	if err != nil {
+		t.Fatalf("could not create snapshot: %v", err)
+	}
+	_, err = db.Snapshot(context.Background(), snapshot)
+	snapshot.Close()
+	db.store.Close()
+	if err != nil {
+		t.Fatalf("could not take snapshot: %v", err)
+	}
+
+	restoredPath := filepath.Join(dir, "restored.db")
+	for i := 0; i < 3; i++ {
+		if err := Restore(&config.BoltConfig{Path: restoredPath}, snapshotPath); err != nil {
+			t.Fatalf("could not restore snapshot: %v", err)
+		}
+	}
+
+	// The first restore had nothing to replace
+	backups, _ := filepath.Glob(restoredPath + ".*.bak")
+	if len(backups) != 2 {
+		t.Errorf("every replaced database should be kept; got %v", backups)
+	}
+}
+
+func TestValidateSnapshotInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "garbage.db")
+	if err := os.WriteFile(path, []byte("not a bolt database"), 0600); err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+
+	if _, err := ValidateSnapshot(path); err == nil {
+		t.Errorf("garbage file should not be a valid snapshot")
+	}
+}
+
+func TestHits(t *testing.T) {
+	db, err := Init(&config.BoltConfig{Path: filepath.Join(t.TempDir(), "goto.db")})
+	if err != nil {
+		t.Fatalf("could not init bolt db: %v", err)
+	}
+	defer db.store.Close()
+
+	ctx := context.Background()
+	for _, id := range []string{"github", "gitlab"} {
+		if err := db.CreateLink(ctx, &models.Link{ID: id, URL: "https://" + id + ".com", Kind: models.Standard}); err != nil {
+			t.Fatalf("could not create link: %v", err)
+		}
+	}
+
+	start := time.Date(2024, 3, 1, 10, 15, 0, 0, time.UTC)
+	hits := []struct {
+		id string
+		at time.Time
+	}{
+		{"github", start},
+		{"github", start.Add(30 * time.Minute)},
+		{"github", start.Add(2 * time.Hour)},
+		{"gitlab", start.Add(time.Hour)},
+	}
+	for _, hit := range hits {
+		if err := db.BumpHitCount(ctx, hit.id, hit.at); err != nil {
+			t.Fatalf("could not bump hit count: %v", err)
+		}
+	}
+
+	hourly, err := db.GetHits(ctx, "github", models.Hourly, start.Add(-24*time.Hour), start.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("could not get hits: %v", err)
+	}
+	wantHourly := []models.HitBucket{
+		{Start: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC).Unix(), Hits: 2},
+		{Start: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC).Unix(), Hits: 1},
+	}
+	if !reflect.DeepEqual(hourly, wantHourly) {
+		t.Errorf("hourly hits mismatch; want %v; got %v", wantHourly, hourly)
+	}
+
+	daily, err := db.GetHits(ctx, "", models.Daily, start.Add(-24*time.Hour), start.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("could not get hits: %v", err)
+	}
+	wantDaily := []models.HitBucket{{Start: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).Unix(), Hits: 4}}
+	if !reflect.DeepEqual(daily, wantDaily) {
+		t.Errorf("daily hits for all links mismatch; want %v; got %v", wantDaily, daily)
+	}
+
+	totals, err := db.GetHitTotals(ctx, models.Hourly, models.Hourly.BucketStart(start.Add(time.Hour)), start.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("could not get hit totals: %v", err)
+	}
+	wantTotals := map[string]int64{"github": 1, "gitlab": 1}
+	if !reflect.DeepEqual(totals, wantTotals) {
+		t.Errorf("hit totals mismatch; want %v; got %v", wantTotals, totals)
+	}
+
+	// Recording a hit long after the others expires the old hourly buckets but not the daily ones
+	later := start.Add(storage.HourlyHitRetention + 24*time.Hour)
+	if err := db.BumpHitCount(ctx, "github", later); err != nil {
+		t.Fatalf("could not bump hit count: %v", err)
+	}
+
+	hourly, err = db.GetHits(ctx, "github", models.Hourly, start.Add(-24*time.Hour), later.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("could not get hits: %v", err)
+	}
+	if len(hourly) != 1 {
+		t.Errorf("expired hourly buckets should be pruned; got %v", hourly)
+	}
+
+	daily, err = db.GetHits(ctx, "github", models.Daily, start.Add(-24*time.Hour), later.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("could not get hits: %v", err)
+	}
+	if len(daily) != 2 {
+		t.Errorf("daily buckets should be kept; got %v", daily)
+	}
+
+	if err := db.DeleteLink(ctx, "github"); err != nil {
+		t.Fatalf("could not delete link: %v", err)
+	}
+
+	daily, err = db.GetHits(ctx, "github", models.Daily, start.Add(-24*time.Hour), later.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("could not get hits: %v", err)
+	}
+	if len(daily) != 0 {
+		t.Errorf("hits should be deleted with their link; got %v", daily)
+	}
+}
+
+func TestTags(t *testing.T) {
+	db, err := Init(&config.BoltConfig{Path: filepath.Join(t.TempDir(), "goto.db")})
+	if err != nil {
+		t.Fatalf("could not init bolt db: %v", err)
+	}
+	defer db.store.Close()
+
+	ctx := context.Background()
+	links := []models.Link{
+		{ID: "pager", URL: "https://pager.example.com", Tags: []string{"oncall", "sre"}},
+		{ID: "runbooks", URL: "https://wiki.example.com/runbooks", Tags: []string{"oncall"}},
+		{ID: "github", URL: "https://github.com"},
+	}
+	for _, link := range links {
+		if err := db.CreateLink(ctx, &link); err != nil {
+			t.Fatalf("could not create link: %v", err)
+		}
+	}
+
+	tagged, err := db.GetLinksByTag(ctx, "oncall")
+	if err != nil {
+		t.Fatalf("could not get links by tag: %v", err)
+	}
+	if len(tagged) != 2 || !reflect.DeepEqual(tagged["pager"], links[0]) {
+		t.Errorf("unexpected links tagged oncall; got %v", tagged)
+	}
+
+	err = db.UpdateLink(ctx, "pager", func(link *models.Link) error {
+		link.Tags = []string{"incidents"}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not update link: %v", err)
+	}
+	if err := db.DeleteLink(ctx, "runbooks"); err != nil {
+		t.Fatalf("could not delete link: %v", err)
+	}
+
+	tags, err := db.GetTags(ctx)
+	if err != nil {
+		t.Fatalf("could not get tags: %v", err)
+	}
+	want := map[string]int64{"incidents": 1}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags should follow updates and deletes; want %v; got %v", want, tags)
+	}
+}
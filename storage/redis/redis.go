@@ -1,11 +1,20 @@
 package redis
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/clintjedwards/goto/config"
+	utilErrors "github.com/clintjedwards/goto/errors"
 	"github.com/clintjedwards/goto/models"
-	"github.com/clintjedwards/toolkit/tkerrors"
+	"github.com/clintjedwards/goto/storage"
 	"github.com/go-redis/redis/v7"
 	"github.com/rs/zerolog/log"
 )
@@ -32,19 +41,19 @@ func Init(config *config.RedisConfig) (Redis, error) {
 	}
 
 	db.store = client
-	log.Info().Str("host", config.Host).Msg("connected toredis")
+	log.Info().Str("host", config.Host).Msg("connected to redis")
 
 	return db, nil
 }
 
 // GetLink returns a link by short name
-func (db *Redis) GetLink(id string) (models.Link, error) {
+func (db *Redis) GetLink(ctx context.Context, id string) (models.Link, error) {
 
 	storedLink := models.Link{}
 
-	linkRaw, err := db.store.Get(id).Bytes()
+	linkRaw, err := db.store.WithContext(ctx).Get(id).Bytes()
 	if err == redis.Nil {
-		return models.Link{}, tkerrors.ErrEntityNotFound
+		return models.Link{}, utilErrors.ErrNotFound
 	}
 	if err != nil {
 		return models.Link{}, err
@@ -59,22 +68,31 @@ func (db *Redis) GetLink(id string) (models.Link, error) {
 }
 
 // GetAllLinks returns an unpaginated list of current links
-func (db *Redis) GetAllLinks() (map[string]models.Link, error) {
+func (db *Redis) GetAllLinks(ctx context.Context) (map[string]models.Link, error) {
 
 	results := map[string]models.Link{}
+	store := db.store.WithContext(ctx)
 
 	var cursor uint64
 
 	for {
-		keys, cursor, err := db.store.Scan(cursor, "*", 10).Result()
+		var keys []string
+		var err error
+
+		keys, cursor, err = store.Scan(cursor, "*", 10).Result()
 		if err != nil {
 			return nil, err
 		}
 
 		for _, key := range keys {
+			// Hits, visits and tags are kept alongside links under keys that can't be link IDs
+			if isHitsKey(key) || isVisitsKey(key) || isTagsKey(key) {
+				continue
+			}
+
 			var storedLink models.Link
 
-			linkRaw, err := db.store.Get(key).Bytes()
+			linkRaw, err := store.Get(key).Bytes()
 			if err != nil {
 				return nil, err
 			}
@@ -96,66 +114,441 @@ func (db *Redis) GetAllLinks() (map[string]models.Link, error) {
 }
 
 // CreateLink stores a new link into database
-func (db *Redis) CreateLink(link *models.Link) error {
+func (db *Redis) CreateLink(ctx context.Context, link *models.Link) error {
 
 	encodedLink, err := json.Marshal(link)
 	if err != nil {
 		return err
 	}
 
-	set, err := db.store.SetNX(link.ID, encodedLink, 0).Result()
+	store := db.store.WithContext(ctx)
+
+	set, err := store.SetNX(link.ID, encodedLink, 0).Result()
+	if err != nil {
+		return err
+	}
 	if !set {
-		return tkerrors.ErrEntityExists
+		return utilErrors.ErrExists
+	}
+	if len(link.Tags) == 0 {
+		return nil
 	}
+
+	_, err = store.TxPipelined(func(pipe redis.Pipeliner) error {
+		indexTags(pipe, link.ID, nil, link.Tags)
+		return nil
+	})
+	return err
+}
+
+// maxUpdateAttempts is how many times an update is tried before giving up on a link that keeps
+// being changed concurrently
+const maxUpdateAttempts = 10
+
+// updateBackoff is how long, at most, the first retry of an update waits; each later retry may
+// wait that much longer. The wait is random so that updates racing for a busy link spread out.
+const updateBackoff = 5 * time.Millisecond
+
+// UpdateLink applies update to a stored link, retrying if the link is changed concurrently
+func (db *Redis) UpdateLink(ctx context.Context, id string, update func(link *models.Link) error) error {
+	store := db.store.WithContext(ctx)
+
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := store.Watch(func(tx *redis.Tx) error {
+			linkRaw, err := tx.Get(id).Bytes()
+			if err == redis.Nil {
+				return utilErrors.ErrNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			var storedLink models.Link
+			err = json.Unmarshal(linkRaw, &storedLink)
+			if err != nil {
+				return err
+			}
+
+			currentTags := slices.Clone(storedLink.Tags)
+
+			err = update(&storedLink)
+			if err != nil {
+				return err
+			}
+
+			encodedLink, err := json.Marshal(storedLink)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+				indexTags(pipe, id, currentTags, storedLink.Tags)
+				return pipe.Set(id, encodedLink, 0).Err()
+			})
+			return err
+		}, id)
+		if err == redis.TxFailedErr {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(rand.N(time.Duration(attempt+1) * updateBackoff)):
+			}
+			continue
+		}
+
+		return err
+	}
+
+	return fmt.Errorf("could not update link %s after %d attempts: %w", id, maxUpdateAttempts, redis.TxFailedErr)
+}
+
+// BumpHitCount updates the hit number on a certain link and records the hit in its time series.
+// The link is updated like any other change to it so that neither overwrites the other.
+func (db *Redis) BumpHitCount(ctx context.Context, id string, at time.Time) error {
+	err := db.UpdateLink(ctx, id, func(link *models.Link) error {
+		link.Hits++
+		if at.Unix() > link.LastAccessed {
+			link.LastAccessed = at.Unix()
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
+
+	return db.recordHit(ctx, id, at)
+}
+
+// hitsKeyPrefix starts the keys of hashes holding hit buckets. Link IDs can't contain a colon
+// so these never collide with links.
+const hitsKeyPrefix = string(storage.HitsBucket) + ":"
+
+// hitsKey is the hash holding a link's hit buckets at a granularity, keyed by bucket start
+func hitsKey(granularity models.Granularity, id string) string {
+	return hitsKeyPrefix + string(granularity) + ":" + id
+}
+
+func isHitsKey(key string) bool {
+	return strings.HasPrefix(key, hitsKeyPrefix)
+}
+
+// recordHit increments the hit buckets of the given link that cover at
+func (db *Redis) recordHit(ctx context.Context, id string, at time.Time) error {
+	store := db.store.WithContext(ctx)
+
+	for _, granularity := range models.Granularities {
+		key := hitsKey(granularity, id)
+		count, err := store.HIncrBy(key, strconv.FormatInt(granularity.BucketStart(at).Unix(), 10), 1).Result()
+		if err != nil {
+			return err
+		}
+
+		// Expired buckets only need to be looked for once each time a new bucket is started
+		if granularity == models.Hourly && count == 1 {
+			err = db.pruneHits(ctx, key, at.Add(-storage.HourlyHitRetention))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
-// BumpHitCount updates the hit number on a certain link
-func (db *Redis) BumpHitCount(id string) error {
+// pruneHits removes hit buckets that start before cutoff
+func (db *Redis) pruneHits(ctx context.Context, key string, cutoff time.Time) error {
+	store := db.store.WithContext(ctx)
 
-	err := db.store.Watch(func(tx *redis.Tx) error {
+	starts, err := store.HKeys(key).Result()
+	if err != nil {
+		return err
+	}
 
-		linkRaw, err := tx.Get(id).Bytes()
-		if err == redis.Nil {
-			return tkerrors.ErrEntityNotFound
+	expired := []string{}
+	for _, start := range starts {
+		unix, _ := strconv.ParseInt(start, 10, 64)
+		if unix < cutoff.Unix() {
+			expired = append(expired, start)
 		}
+	}
+
+	if len(expired) == 0 {
+		return nil
+	}
+
+	return store.HDel(key, expired...).Err()
+}
+
+// GetHits returns a link's hits in order, or the hits of all links when id is empty
+func (db *Redis) GetHits(ctx context.Context, id string, granularity models.Granularity,
+	from, to time.Time) ([]models.HitBucket, error) {
+	keys := []string{hitsKey(granularity, id)}
+	if id == "" {
+		var err error
+		keys, err = db.scanKeys(ctx, hitsKey(granularity, "*"))
 		if err != nil {
-			return err
+			return nil, err
 		}
+	}
 
-		var storedLink models.Link
-		err = json.Unmarshal(linkRaw, &storedLink)
+	hits := map[int64]int64{}
+	for _, key := range keys {
+		err := db.sumHits(ctx, key, from, to, hits)
 		if err != nil {
-			return err
+			return nil, err
 		}
+	}
 
-		storedLink.Hits++
+	buckets := []models.HitBucket{}
+	for start, count := range hits {
+		buckets = append(buckets, models.HitBucket{Start: start, Hits: count})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start < buckets[j].Start })
+
+	return buckets, nil
+}
 
-		encodedLink, err := json.Marshal(storedLink)
+// GetHitTotals returns the number of hits each link received within [from, to)
+func (db *Redis) GetHitTotals(ctx context.Context, granularity models.Granularity,
+	from, to time.Time) (map[string]int64, error) {
+	prefix := hitsKey(granularity, "")
+
+	keys, err := db.scanKeys(ctx, prefix+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	totals := map[string]int64{}
+	for _, key := range keys {
+		hits := map[int64]int64{}
+		err := db.sumHits(ctx, key, from, to, hits)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		err = tx.Set(id, encodedLink, 0).Err()
-		if err != nil {
-			return err
+		for _, count := range hits {
+			totals[strings.TrimPrefix(key, prefix)] += count
 		}
+	}
+
+	return totals, nil
+}
 
+// sumHits adds the counts of every bucket in the hash at key starting within [from, to) to hits
+func (db *Redis) sumHits(ctx context.Context, key string, from, to time.Time, hits map[int64]int64) error {
+	buckets, err := db.store.WithContext(ctx).HGetAll(key).Result()
+	if err != nil {
 		return err
-	})
+	}
+
+	for start, count := range buckets {
+		unix, _ := strconv.ParseInt(start, 10, 64)
+		if unix < from.Unix() || unix >= to.Unix() {
+			continue
+		}
+
+		value, _ := strconv.ParseInt(count, 10, 64)
+		hits[unix] += value
+	}
+
+	return nil
+}
+
+// scanKeys returns every key matching pattern
+func (db *Redis) scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	store := db.store.WithContext(ctx)
+	results := []string{}
+
+	var cursor uint64
+	for {
+		var keys []string
+		var err error
+
+		keys, cursor, err = store.Scan(cursor, pattern, 100).Result()
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, keys...)
+
+		if cursor == 0 {
+			return results, nil
+		}
+	}
+}
+
+// visitsKeyPrefix starts the keys of hashes holding visit counts, which like hits can't
+// collide with links
+const visitsKeyPrefix = string(storage.VisitsBucket) + ":"
+
+// visitsKey is the hash holding a link's visit counts for a dimension, keyed by value
+func visitsKey(dimension models.VisitDimension, id string) string {
+	return visitsKeyPrefix + string(dimension) + ":" + id
+}
+
+func isVisitsKey(key string) bool {
+	return strings.HasPrefix(key, visitsKeyPrefix)
+}
+
+// recordVisitScript counts a visit's values in one step so that concurrent visits can't take a
+// hash past the most values kept. KEYS are the link followed by a visits hash for each value in
+// ARGV after the most values kept and the value counted once there are that many.
+var recordVisitScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+for i = 2, #KEYS do
+	local value = ARGV[i + 1]
+	if redis.call('HEXISTS', KEYS[i], value) == 0 and redis.call('HLEN', KEYS[i]) >= tonumber(ARGV[1]) then
+		value = ARGV[2]
+	end
+	redis.call('HINCRBY', KEYS[i], value, 1)
+end
+return 1
+`)
+
+// RecordVisit counts each collected attribute of a visit
+func (db *Redis) RecordVisit(ctx context.Context, id string, visit models.Visit) error {
+	store := db.store.WithContext(ctx)
+
+	keys := []string{id}
+	args := []interface{}{storage.MaxVisitValues, storage.OtherVisitValue}
+	for dimension, value := range visit.Values() {
+		keys = append(keys, visitsKey(dimension, id))
+		args = append(args, value)
+	}
+
+	recorded, err := recordVisitScript.Run(store, keys, args...).Int()
 	if err != nil {
 		return err
 	}
+	if recorded == 0 {
+		return utilErrors.ErrNotFound
+	}
 
 	return nil
 }
 
-// DeleteLink removes a link from the database
-func (db *Redis) DeleteLink(id string) error {
+// GetVisits returns the number of visits to a link for each value of each dimension
+func (db *Redis) GetVisits(ctx context.Context, id string) (map[models.VisitDimension]map[string]int64, error) {
+	store := db.store.WithContext(ctx)
+	visits := map[models.VisitDimension]map[string]int64{}
+
+	for _, dimension := range models.VisitDimensions {
+		counts, err := store.HGetAll(visitsKey(dimension, id)).Result()
+		if err != nil {
+			return nil, err
+		}
 
-	err := db.store.Del(id).Err()
+		visits[dimension] = map[string]int64{}
+		for value, count := range counts {
+			visits[dimension][value], _ = strconv.ParseInt(count, 10, 64)
+		}
+	}
+
+	return visits, nil
+}
+
+// DeleteLink removes a link, its hits, its visits and its tags from the database
+func (db *Redis) DeleteLink(ctx context.Context, id string) error {
+	keys := []string{id}
+	for _, granularity := range models.Granularities {
+		keys = append(keys, hitsKey(granularity, id))
+	}
+	for _, dimension := range models.VisitDimensions {
+		keys = append(keys, visitsKey(dimension, id))
+	}
+
+	link, err := db.GetLink(ctx, id)
+	if err != nil && err != utilErrors.ErrNotFound {
+		return err
+	}
+
+	_, err = db.store.WithContext(ctx).TxPipelined(func(pipe redis.Pipeliner) error {
+		indexTags(pipe, id, link.Tags, nil)
+		return pipe.Del(keys...).Err()
+	})
 	return err
 }
+
+// tagsKeyPrefix starts the keys of sets holding the ids of the links with each tag, which like
+// hits can't collide with links
+const tagsKeyPrefix = string(storage.TagsBucket) + ":"
+
+// tagsKey is the set holding the ids of the links tagged with tag
+func tagsKey(tag string) string {
+	return tagsKeyPrefix + tag
+}
+
+func isTagsKey(key string) bool {
+	return strings.HasPrefix(key, tagsKeyPrefix)
+}
+
+// indexTags queues the changes to the tag index for a link whose tags changed from current to
+// updated. Redis removes sets once they are empty, so unused tags disappear on their own.
+func indexTags(pipe redis.Pipeliner, id string, current, updated []string) {
+	added, removed := storage.TagChanges(current, updated)
+	for _, tag := range added {
+		pipe.SAdd(tagsKey(tag), id)
+	}
+	for _, tag := range removed {
+		pipe.SRem(tagsKey(tag), id)
+	}
+}
+
+// GetLinksByTag returns the links tagged with tag
+func (db *Redis) GetLinksByTag(ctx context.Context, tag string) (map[string]models.Link, error) {
+	ids, err := db.store.WithContext(ctx).SMembers(tagsKey(tag)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	results := map[string]models.Link{}
+	for _, id := range ids {
+		link, err := db.GetLink(ctx, id)
+		if err == utilErrors.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		results[id] = link
+	}
+
+	return results, nil
+}
+
+// GetTags returns the number of links with each tag in use
+func (db *Redis) GetTags(ctx context.Context) (map[string]int64, error) {
+	keys, err := db.scanKeys(ctx, tagsKeyPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	tags := map[string]int64{}
+	for _, key := range keys {
+		count, err := db.store.WithContext(ctx).SCard(key).Result()
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			tags[strings.TrimPrefix(key, tagsKeyPrefix)] = count
+		}
+	}
+
+	return tags, nil
+}
+
+// Ping checks that the redis server is reachable
+func (db *Redis) Ping(ctx context.Context) error {
+	return db.store.WithContext(ctx).Ping().Err()
+}
+
+// Close closes the connection pool to the redis server
+func (db *Redis) Close() error {
+	return db.store.Close()
+}
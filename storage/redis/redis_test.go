@@ -0,0 +1,95 @@
+package redis
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clintjedwards/goto/config"
+	"github.com/clintjedwards/goto/models"
+)
+
+// newTestRedis connects to the redis server named by GOTO_TEST_REDIS_HOST, skipping the test
+// when there isn't one
+func newTestRedis(t *testing.T) Redis {
+	t.Helper()
+
+	host := os.Getenv("GOTO_TEST_REDIS_HOST")
+	if host == "" {
+		t.Skip("GOTO_TEST_REDIS_HOST is not set")
+	}
+
+	db, err := Init(&config.RedisConfig{Host: host})
+	if err != nil {
+		t.Fatalf("could not connect to redis: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestConcurrentHitsAndUpdates(t *testing.T) {
+	db := newTestRedis(t)
+	ctx := context.Background()
+
+	link := models.Link{ID: "concurrent-hits-test", URL: "https://example.com", Kind: models.Routed,
+		Destinations: []models.Destination{{URL: "https://a.example.com"}, {URL: "https://b.example.com"}}}
+	_ = db.DeleteLink(ctx, link.ID)
+	if err := db.CreateLink(ctx, &link); err != nil {
+		t.Fatalf("could not create link: %v", err)
+	}
+	t.Cleanup(func() { _ = db.DeleteLink(ctx, link.ID) })
+
+	const visits = 20
+	at := time.Unix(1700000000, 0)
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 2*visits+1)
+	for i := 0; i < visits; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs <- db.BumpHitCount(ctx, link.ID, at.Add(time.Duration(i)*time.Second))
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- db.UpdateLink(ctx, link.ID, func(link *models.Link) error {
+				link.Destinations[i%2].Hits++
+				return nil
+			})
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errs <- db.UpdateLink(ctx, link.ID, func(link *models.Link) error {
+			link.Archived = at.Unix()
+			return nil
+		})
+	}()
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("could not update link: %v", err)
+		}
+	}
+
+	got, err := db.GetLink(ctx, link.ID)
+	if err != nil {
+		t.Fatalf("could not retrieve link: %v", err)
+	}
+
+	if got.Hits != visits || got.Destinations[0].Hits+got.Destinations[1].Hits != visits {
+		t.Errorf("no hits should be lost; want %d; got %d and destinations %+v", visits, got.Hits, got.Destinations)
+	}
+	if got.LastAccessed != at.Add((visits-1)*time.Second).Unix() {
+		t.Errorf("last accessed should be the latest hit; got %d", got.LastAccessed)
+	}
+	if got.Archived != at.Unix() {
+		t.Errorf("concurrent updates should not be undone by hits; got archived %d", got.Archived)
+	}
+}
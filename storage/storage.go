@@ -1,6 +1,11 @@
 package storage
 
 import (
+	"context"
+	"io"
+	"slices"
+	"time"
+
 	"github.com/clintjedwards/goto/models"
 )
 
@@ -12,8 +17,25 @@ type Bucket string
 const (
 	// LinksBucket represents the container in which shortened links are managed
 	LinksBucket Bucket = "links"
+	// HitsBucket represents the container in which each link's hits over time are managed
+	HitsBucket Bucket = "hits"
+	// VisitsBucket represents the container in which counts of where each link's visits came from are managed
+	VisitsBucket Bucket = "visits"
+	// TagsBucket represents the container in which the links with each tag are indexed
+	TagsBucket Bucket = "tags"
 )
 
+// HourlyHitRetention is how long hourly hit buckets are kept; daily buckets are kept forever
+const HourlyHitRetention = 30 * 24 * time.Hour
+
+// MaxVisitValues limits how many distinct values are counted for each link and visit dimension,
+// so that links visited from many places can't grow without bound. Visits with new values past
+// the limit are counted under OtherVisitValue instead.
+const MaxVisitValues = 1000
+
+// OtherVisitValue counts visits whose value arrived after MaxVisitValues was reached
+const OtherVisitValue = "(other)"
+
 // EngineType represents the different possible storage engines available
 type EngineType string
 
@@ -28,9 +50,53 @@ const (
 
 // Engine represents backend storage implementations where items can be persisted
 type Engine interface {
-	GetAllLinks() (map[string]models.Link, error)
-	GetLink(id string) (models.Link, error)
-	CreateLink(link *models.Link) error
-	BumpHitCount(id string) error
-	DeleteLink(id string) error
+	GetAllLinks(ctx context.Context) (map[string]models.Link, error)
+	GetLink(ctx context.Context, id string) (models.Link, error)
+	// GetLinksByTag returns the links tagged with tag
+	GetLinksByTag(ctx context.Context, tag string) (map[string]models.Link, error)
+	// GetTags returns the number of links with each tag in use
+	GetTags(ctx context.Context) (map[string]int64, error)
+	CreateLink(ctx context.Context, link *models.Link) error
+	// BumpHitCount increments a link's lifetime hit count, records the hit at the given time and
+	// updates when the link was last accessed
+	BumpHitCount(ctx context.Context, id string, at time.Time) error
+	DeleteLink(ctx context.Context, id string) error
+	// UpdateLink applies update to the stored link atomically with respect to other changes to it
+	UpdateLink(ctx context.Context, id string, update func(link *models.Link) error) error
+	// RecordVisit counts each collected attribute of a single visit to a link
+	RecordVisit(ctx context.Context, id string, visit models.Visit) error
+	// GetVisits returns the number of visits to a link for each value of each dimension
+	GetVisits(ctx context.Context, id string) (map[models.VisitDimension]map[string]int64, error)
+	// GetHits returns the buckets of a link's hits that start within [from, to) in order,
+	// omitting empty buckets. An empty id returns hits summed across all links.
+	GetHits(ctx context.Context, id string, granularity models.Granularity, from, to time.Time) ([]models.HitBucket, error)
+	// GetHitTotals returns each link's number of hits in buckets starting within [from, to),
+	// omitting links without any
+	GetHitTotals(ctx context.Context, granularity models.Granularity, from, to time.Time) (map[string]int64, error)
+	// Ping checks that the underlying store is reachable and usable
+	Ping(ctx context.Context) error
+	// Close releases the underlying store; the engine must not be used afterwards
+	Close() error
+}
+
+// Snapshotter represents storage engines that are able to produce a consistent
+// point-in-time copy of their data while still serving requests
+type Snapshotter interface {
+	Snapshot(ctx context.Context, w io.Writer) (int64, error)
+}
+
+// TagChanges returns the tags in updated that aren't in current and those in current that aren't
+// in updated, so that engines only touch the parts of their tag index that changed
+func TagChanges(current, updated []string) (added, removed []string) {
+	for _, tag := range updated {
+		if !slices.Contains(current, tag) {
+			added = append(added, tag)
+		}
+	}
+	for _, tag := range current {
+		if !slices.Contains(updated, tag) {
+			removed = append(removed, tag)
+		}
+	}
+	return added, removed
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/rs/zerolog/log"
+)
+
+// notifySystemd informs systemd of a change in service state when running as a Type=notify unit.
+// Outside of systemd it does nothing.
+func notifySystemd(state string) {
+	_, err := daemon.SdNotify(false, state)
+	if err != nil {
+		log.Warn().Err(err).Str("state", state).Msg("could not notify systemd")
+	}
+}
+
+// runWatchdog pings the systemd watchdog for as long as the storage engine stays reachable,
+// so that systemd restarts the service if it is no longer able to serve links.
+// It returns once ctx is canceled, or immediately if the unit has no watchdog configured.
+func (app *app) runWatchdog(ctx context.Context) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		log.Error().Err(err).Msg("could not read systemd watchdog settings")
+		return
+	}
+	if interval == 0 {
+		return
+	}
+
+	log.Info().Dur("interval", interval).Msg("systemd watchdog enabled")
+
+	// Ping twice per interval so a single slow health check doesn't get us killed
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, interval/2)
+			err := app.storage.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				log.Error().Err(err).Msg("storage engine unavailable; withholding watchdog ping")
+				continue
+			}
+
+			notifySystemd(daemon.SdNotifyWatchdog)
+		}
+	}
+}
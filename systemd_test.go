@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+)
+
+// newNotifySocket stands in for systemd's notification socket and returns it for reading
+func newNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("could not listen for notifications: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	return conn
+}
+
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no notification received: %v", err)
+	}
+
+	return string(buf[:n])
+}
+
+func TestNotifySystemd(t *testing.T) {
+	conn := newNotifySocket(t)
+
+	notifySystemd(daemon.SdNotifyReady)
+	if got := readNotification(t, conn); got != daemon.SdNotifyReady {
+		t.Errorf("unexpected notification; want %q; got %q", daemon.SdNotifyReady, got)
+	}
+}
+
+func TestRunWatchdog(t *testing.T) {
+	conn := newNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", strconv.Itoa(int((100 * time.Millisecond).Microseconds())))
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	app := newTestApp(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		app.runWatchdog(ctx)
+		close(done)
+	}()
+
+	if got := readNotification(t, conn); got != daemon.SdNotifyWatchdog {
+		t.Errorf("unexpected notification; want %q; got %q", daemon.SdNotifyWatchdog, got)
+	}
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+	"github.com/rs/zerolog/log"
+)
+
+// listTagsHandler returns every tag in use with the number of links that have it, most used first
+func (app *app) listTagsHandler(w http.ResponseWriter, req *http.Request) {
+	counts, err := app.storage.GetTags(req.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("error retrieving tags")
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve tags", err))
+		return
+	}
+
+	tags := []models.TagCount{}
+	for tag, links := range counts {
+		tags = append(tags, models.TagCount{Tag: tag, Links: links})
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Links != tags[j].Links {
+			return tags[i].Links > tags[j].Links
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+
+	sendResponse(w, http.StatusOK, map[string]interface{}{"tags": tags})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/clintjedwards/goto/models"
+)
+
+func TestListLinksByTag(t *testing.T) {
+	app := newTestApp(t)
+	router, err := app.newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	for _, link := range []models.Link{
+		{ID: "pager", URL: "https://pager.example.com", Tags: []string{"oncall", "sre"}},
+		{ID: "runbooks", URL: "https://wiki.example.com/runbooks", Tags: []string{"oncall"}},
+		{ID: "github", URL: "https://github.com"},
+	} {
+		err := app.storage.CreateLink(context.Background(), &link)
+		if err != nil {
+			t.Fatalf("could not create link: %v", err)
+		}
+	}
+
+	tests := map[string][]string{
+		"/links?tag=oncall":         {"pager", "runbooks"},
+		"/links?tag=OnCall&tag=sre": {"pager"},
+		"/links?tag=missing":        {},
+	}
+
+	for query, want := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", apiVersionPath+query, nil))
+
+		links := map[string]models.Link{}
+		err := json.NewDecoder(recorder.Body).Decode(&links)
+		if err != nil {
+			t.Fatalf("could not decode links: %v", err)
+		}
+
+		got := sortedLinkIDs(links)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected links for %s; want %v; got %v", query, want, got)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", apiVersionPath+"/tags", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("unexpected status; want %d; got %d", http.StatusOK, recorder.Code)
+	}
+
+	var body struct {
+		Tags []models.TagCount `json:"tags"`
+	}
+	err = json.NewDecoder(recorder.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("could not decode tags: %v", err)
+	}
+
+	want := []models.TagCount{{Tag: "oncall", Links: 2}, {Tag: "sre", Links: 1}}
+	if !reflect.DeepEqual(body.Tags, want) {
+		t.Errorf("unexpected tags; want %v; got %v", want, body.Tags)
+	}
+}
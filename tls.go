@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/clintjedwards/goto/config"
+	"github.com/rs/zerolog/log"
+)
+
+// certReloadInterval is how often certificate files are checked for changes
+const certReloadInterval = 10 * time.Second
+
+// certReloader serves a certificate loaded from disk and picks up new versions of the
+// certificate and key as they are replaced, so renewals don't require a restart.
+type certReloader struct {
+	certPath string
+	keyPath  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certPath, keyPath string) (*certReloader, error) {
+	reloader := &certReloader{certPath: certPath, keyPath: keyPath}
+
+	_, err := reloader.reloadIfChanged()
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// GetCertificate returns the most recently loaded certificate. It satisfies tls.Config.GetCertificate.
+func (c *certReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+// reloadIfChanged loads the certificate and key if either file has been modified since the
+// last load. A pair that fails to load leaves the previous certificate in place.
+func (c *certReloader) reloadIfChanged() (bool, error) {
+	modTime, err := latestModTime(c.certPath, c.keyPath)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.RLock()
+	unchanged := c.cert != nil && modTime.Equal(c.modTime)
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.mu.Unlock()
+
+	return true, nil
+}
+
+// watch checks for updated certificate files until ctx is canceled
+func (c *certReloader) watch(ctx context.Context) {
+	ticker := time.NewTicker(certReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := c.reloadIfChanged()
+			if err != nil {
+				log.Error().Err(err).Str("cert", c.certPath).Msg("could not reload certificate; keeping previous")
+				continue
+			}
+			if reloaded {
+				log.Info().Str("cert", c.certPath).Msg("reloaded certificate")
+			}
+		}
+	}
+}
+
+func latestModTime(paths ...string) (time.Time, error) {
+	latest := time.Time{}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// httpsRedirectMiddleware sends requests made over plain http to the https listener.
+// Short links are left alone since they are typed into browsers as plain http (go/link),
+// as are requests arriving through the unix socket from a reverse proxy terminating tls itself.
+func httpsRedirectMiddleware(config *config.TLSConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if connListener(req.Context()) != httpListener || routeTemplate(req) == "/" {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			host, _, err := net.SplitHostPort(req.Host)
+			if err != nil {
+				host = req.Host
+			}
+
+			_, port, err := net.SplitHostPort(config.Host)
+			if err == nil && port != "443" {
+				host = net.JoinHostPort(host, port)
+			}
+
+			http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusPermanentRedirect)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/clintjedwards/goto/config"
+	"github.com/gorilla/mux"
+)
+
+// writeTestCert writes a self-signed certificate with the given common name and its key to dir
+func writeTestCert(t *testing.T, dir, commonName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("could not create certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("could not marshal key: %v", err)
+	}
+
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	err = os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600)
+	if err != nil {
+		t.Fatalf("could not write certificate: %v", err)
+	}
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatalf("could not write key: %v", err)
+	}
+
+	return certPath, keyPath
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCert(t, dir, "first")
+
+	reloader, err := newCertReloader(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("could not load certificate: %v", err)
+	}
+
+	reloaded, err := reloader.reloadIfChanged()
+	if err != nil || reloaded {
+		t.Errorf("unchanged certificate should not be reloaded; reloaded %v, err %v", reloaded, err)
+	}
+
+	writeTestCert(t, dir, "second")
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certPath, future, future)
+
+	reloaded, err = reloader.reloadIfChanged()
+	if err != nil || !reloaded {
+		t.Fatalf("replaced certificate should be reloaded; reloaded %v, err %v", reloaded, err)
+	}
+
+	cert, _ := reloader.GetCertificate(nil)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("could not parse certificate: %v", err)
+	}
+	if parsed.Subject.CommonName != "second" {
+		t.Errorf("expected reloaded certificate; got %q", parsed.Subject.CommonName)
+	}
+}
+
+func TestHTTPSRedirectMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/links", func(w http.ResponseWriter, _ *http.Request) {})
+	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+	router.Use(httpsRedirectMiddleware(&config.TLSConfig{Host: "0.0.0.0:8443"}))
+
+	tests := map[string]struct {
+		path     string
+		location string
+	}{
+		"api route": {
+			path:     "/links?sort=hits",
+			location: "https://go.example.com:8443/links?sort=hits",
+		},
+		"short link": {
+			path:     "/github",
+			location: "",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "http://go.example.com"+tc.path, nil)
+			router.ServeHTTP(recorder, req)
+
+			if location := recorder.Header().Get("Location"); location != tc.location {
+				t.Errorf("unexpected redirect; want %q; got %q", tc.location, location)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/clintjedwards/goto/config"
+	"github.com/felixge/httpsnoop"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is used for all spans created by goto. It delegates to whichever
+// provider is registered globally, so it is safe to use before tracing is configured.
+var tracer = otel.Tracer("github.com/clintjedwards/goto")
+
+// tracingExporter represents the different places spans can be sent
+type tracingExporter string
+
+const (
+	tracingExporterNone   tracingExporter = "none"
+	tracingExporterStdout tracingExporter = "stdout"
+	tracingExporterOTLP   tracingExporter = "otlp"
+)
+
+// initTracing registers a global tracer provider with the configured exporter and sets up
+// W3C trace-context propagation. The returned function flushes any pending spans and should be
+// called before the process exits.
+func initTracing(config *config.TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var spanProcessor sdktrace.SpanProcessor
+
+	switch tracingExporter(config.Exporter) {
+	case tracingExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case tracingExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, err
+		}
+		// Spans are written immediately so they can be inspected while testing locally
+		spanProcessor = sdktrace.NewSimpleSpanProcessor(exporter)
+	case tracingExporterOTLP:
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.Endpoint)}
+		if config.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+
+		exporter, err := otlptracehttp.New(context.Background(), options...)
+		if err != nil {
+			return nil, err
+		}
+		spanProcessor = sdktrace.NewBatchSpanProcessor(exporter)
+	default:
+		return nil, fmt.Errorf("tracing exporter not implemented: %s", config.Exporter)
+	}
+
+	tracerResource, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("goto"), semconv.ServiceVersion(version)))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(spanProcessor),
+		sdktrace.WithResource(tracerResource),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	log.Info().Str("exporter", config.Exporter).Float64("sample_ratio", config.SampleRatio).
+		Msg("tracing enabled")
+
+	return provider.Shutdown, nil
+}
+
+// tracingMiddleware starts a server span for every request, continuing any trace
+// passed in by the caller through the W3C traceparent header.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+		route := routeTemplate(req)
+
+		ctx, span := tracer.Start(ctx, req.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(req.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(req.URL.Path),
+			))
+		defer span.End()
+
+		captured := httpsnoop.CaptureMetrics(next, w, req.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(captured.Code))
+		if captured.Code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(captured.Code))
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingPropagatesToStorage(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+		// The default global provider keeps delegating to the first one set, so it is shut
+		// down to stop other tests' spans being recorded
+		_ = provider.Shutdown(context.Background())
+	})
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	engine := instrumentStorage(newTestBoltEngine(t, "goto.db"), "bolt")
+
+	router := mux.NewRouter()
+	router.HandleFunc("/links/{id}", func(w http.ResponseWriter, req *http.Request) {
+		_, _ = engine.GetLink(req.Context(), mux.Vars(req)["id"])
+		w.WriteHeader(http.StatusNotFound)
+	})
+	router.Use(tracingMiddleware)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	req := httptest.NewRequest("GET", "/links/github", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected a storage span and a request span; got %d spans", len(spans))
+	}
+
+	storageSpan, requestSpan := spans[0], spans[1]
+
+	if requestSpan.Name != "GET /links/{id}" {
+		t.Errorf("request span named incorrectly; got %q", requestSpan.Name)
+	}
+	if requestSpan.SpanContext.TraceID().String() != traceID {
+		t.Errorf("request span did not continue incoming trace; got trace %s", requestSpan.SpanContext.TraceID())
+	}
+	if storageSpan.Name != "storage.GetLink" {
+		t.Errorf("storage span named incorrectly; got %q", storageSpan.Name)
+	}
+	if storageSpan.Parent.SpanID() != requestSpan.SpanContext.SpanID() {
+		t.Errorf("storage span is not a child of the request span")
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/clintjedwards/goto/config"
+	utilErrors "github.com/clintjedwards/goto/errors"
+	"github.com/clintjedwards/goto/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// directReferrer is counted for visits that weren't referred from another page
+	directReferrer = "(direct)"
+	// unknownReferrer is counted for visits whose referrer couldn't be parsed
+	unknownReferrer = "(unknown)"
+	// defaultVisitLimit is how many values of each dimension are returned by default
+	defaultVisitLimit = 10
+)
+
+// userAgentFamilies maps markers found in user agents to the family they belong to. They are
+// checked in order since most browsers also claim to be the ones before them.
+var userAgentFamilies = []struct {
+	marker string
+	family string
+}{
+	{"bot", "Bot"},
+	{"crawler", "Bot"},
+	{"spider", "Bot"},
+	{"curl/", "curl"},
+	{"wget/", "Wget"},
+	{"httpie/", "HTTPie"},
+	{"go-http-client/", "Go"},
+	{"python-requests/", "Python"},
+	{"edg/", "Edge"},
+	{"opr/", "Opera"},
+	{"firefox/", "Firefox"},
+	{"fxios/", "Firefox"},
+	{"crios/", "Chrome"},
+	{"chrome/", "Chrome"},
+	{"safari/", "Safari"},
+	{"msie ", "Internet Explorer"},
+	{"trident/", "Internet Explorer"},
+}
+
+// newNetworkSalt creates a random salt for hashing networks when none is configured
+func newNetworkSalt() []byte {
+	salt := make([]byte, 32)
+	_, err := rand.Read(salt)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not generate network salt")
+	}
+	return salt
+}
+
+// visitFrom collects the attributes of a visit allowed by the privacy settings
+func (app *app) visitFrom(req *http.Request) models.Visit {
+	settings := app.currentConfig().Visits
+
+	if settings.HonorDoNotTrack && (req.Header.Get("DNT") == "1" || req.Header.Get("Sec-GPC") == "1") {
+		return models.Visit{}
+	}
+
+	visit := models.Visit{}
+
+	if settings.Referrers {
+		visit.Referrer = referrerHost(req.Referer())
+	}
+
+	if settings.UserAgents {
+		visit.UserAgent = userAgentFamily(req.UserAgent())
+	}
+
+	switch settings.Networks {
+	case "hashed", "full":
+		salt := app.networkSalt
+		if settings.NetworkSalt != "" {
+			salt = []byte(settings.NetworkSalt)
+		}
+		visit.Network = clientNetwork(req.RemoteAddr, settings, salt)
+	}
+
+	return visit
+}
+
+// referrerHost reduces a referring url to its host so that paths and query strings, which
+// may identify the visitor, are never stored
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return directReferrer
+	}
+
+	parsed, err := url.Parse(referrer)
+	if err != nil || parsed.Hostname() == "" {
+		return unknownReferrer
+	}
+
+	return strings.ToLower(parsed.Hostname())
+}
+
+// userAgentFamily returns the browser or tool family of a user agent
+func userAgentFamily(userAgent string) string {
+	userAgent = strings.ToLower(userAgent)
+	if userAgent == "" {
+		return "Unknown"
+	}
+
+	for _, family := range userAgentFamilies {
+		if strings.Contains(userAgent, family.marker) {
+			return family.family
+		}
+	}
+
+	return "Other"
+}
+
+// clientNetwork returns the network a client address belongs to, hashed with salt unless
+// full networks are configured. Clients not connected over ip, such as through the unix
+// socket, have no network.
+func clientNetwork(remoteAddr string, settings *config.VisitsConfig, salt []byte) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+
+	bits := 24
+	if addr.Is6() {
+		bits = 48
+	}
+
+	network, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+
+	if settings.Networks == "full" {
+		return network.String()
+	}
+
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(network.String()))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// linkVisitsHandler returns where a link's visits came from
+func (app *app) linkVisitsHandler(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	limit := defaultVisitLimit
+	if value := req.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxTopLimit {
+			sendErrResponse(w, req, utilErrors.New(utilErrors.CodeInvalidRequest,
+				fmt.Sprintf("limit must be between 1 and %d", maxTopLimit)))
+			return
+		}
+	}
+
+	_, err := app.storage.GetLink(req.Context(), id)
+	if err != nil {
+		if errors.Is(err, utilErrors.ErrNotFound) {
+			sendErrResponse(w, req, utilErrors.New(utilErrors.CodeLinkNotFound, "link not found"))
+			return
+		}
+		log.Error().Err(err).Msg("error retrieving link")
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve link", err))
+		return
+	}
+
+	visits, err := app.storage.GetVisits(req.Context(), id)
+	if err != nil {
+		log.Error().Err(err).Str("id", id).Msg("error retrieving visits")
+		sendErrResponse(w, req, utilErrors.Wrap(utilErrors.CodeStorageUnavailable, "could not retrieve visits", err))
+		return
+	}
+
+	sendResponse(w, http.StatusOK, models.VisitBreakdown{
+		ID:         id,
+		Referrers:  rankVisits(visits[models.Referrers], limit),
+		UserAgents: rankVisits(visits[models.UserAgents], limit),
+		Networks:   rankVisits(visits[models.Networks], limit),
+	})
+}
+
+// rankVisits orders values by their number of visits and keeps the first limit
+func rankVisits(counts map[string]int64, limit int) []models.VisitCount {
+	ranked := []models.VisitCount{}
+	for value, hits := range counts {
+		ranked = append(ranked, models.VisitCount{Value: value, Hits: hits})
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Hits != ranked[j].Hits {
+			return ranked[i].Hits > ranked[j].Hits
+		}
+		return ranked[i].Value < ranked[j].Value
+	})
+
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	return ranked
+}
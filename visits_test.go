@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/clintjedwards/goto/config"
+	"github.com/clintjedwards/goto/models"
+)
+
+func TestVisitFrom(t *testing.T) {
+	tests := map[string]struct {
+		settings config.VisitsConfig
+		headers  map[string]string
+		want     models.Visit
+	}{
+		"everything": {
+			settings: config.VisitsConfig{Referrers: true, UserAgents: true, Networks: "full"},
+			headers: map[string]string{
+				"Referer":    "https://News.ycombinator.com/item?id=1",
+				"User-Agent": "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+			},
+			want: models.Visit{Referrer: "news.ycombinator.com", UserAgent: "Firefox", Network: "203.0.113.0/24"},
+		},
+		"direct": {
+			settings: config.VisitsConfig{Referrers: true},
+			want:     models.Visit{Referrer: "(direct)"},
+		},
+		"chrome is not safari": {
+			settings: config.VisitsConfig{UserAgents: true},
+			headers: map[string]string{
+				"User-Agent": "Mozilla/5.0 (Macintosh) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36",
+			},
+			want: models.Visit{UserAgent: "Chrome"},
+		},
+		"networks off": {
+			settings: config.VisitsConfig{Networks: "off"},
+			want:     models.Visit{},
+		},
+		"do not track": {
+			settings: config.VisitsConfig{Referrers: true, UserAgents: true, Networks: "full", HonorDoNotTrack: true},
+			headers:  map[string]string{"DNT": "1", "Referer": "https://github.com"},
+			want:     models.Visit{},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			app := &app{}
+			app.config.Store(&config.Config{Visits: &tc.settings})
+
+			req := httptest.NewRequest("GET", "/github", nil)
+			req.RemoteAddr = "203.0.113.7:52000"
+			for key, value := range tc.headers {
+				req.Header.Set(key, value)
+			}
+
+			got := app.visitFrom(req)
+			if got != tc.want {
+				t.Errorf("unexpected visit; want %+v; got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestClientNetworkHashed(t *testing.T) {
+	settings := &config.VisitsConfig{Networks: "hashed"}
+
+	first := clientNetwork("[2001:db8:1:2::1]:443", settings, []byte("salt"))
+	if first == "" || first == "2001:db8:1::/48" {
+		t.Fatalf("network should be hashed; got %q", first)
+	}
+
+	if same := clientNetwork("[2001:db8:1:ffff::9]:443", settings, []byte("salt")); same != first {
+		t.Errorf("addresses in the same /48 should hash the same; want %q; got %q", first, same)
+	}
+
+	if other := clientNetwork("[2001:db8:1:2::1]:443", settings, []byte("pepper")); other == first {
+		t.Errorf("hashes should depend on the salt")
+	}
+
+	if unix := clientNetwork("@", settings, []byte("salt")); unix != "" {
+		t.Errorf("clients without an ip address should have no network; got %q", unix)
+	}
+}
+
+func TestLinkVisits(t *testing.T) {
+	app := newTestApp(t)
+	router, err := app.newRouter("")
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
+
+	err = app.storage.CreateLink(context.Background(), &models.Link{ID: "github", URL: "https://github.com"})
+	if err != nil {
+		t.Fatalf("could not create link: %v", err)
+	}
+
+	for _, referrer := range []string{"https://news.ycombinator.com/", "https://news.ycombinator.com/new", ""} {
+		req := httptest.NewRequest("GET", "/github", nil)
+		req.Header.Set("Referer", referrer)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	err = app.hits.drain(context.Background())
+	if err != nil {
+		t.Fatalf("could not drain hit recorder: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", apiVersionPath+"/links/github/visits", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("unexpected status; want %d; got %d", http.StatusOK, recorder.Code)
+	}
+
+	var breakdown models.VisitBreakdown
+	err = json.NewDecoder(recorder.Body).Decode(&breakdown)
+	if err != nil {
+		t.Fatalf("could not decode breakdown: %v", err)
+	}
+
+	want := []models.VisitCount{{Value: "news.ycombinator.com", Hits: 2}, {Value: "(direct)", Hits: 1}}
+	if len(breakdown.Referrers) != len(want) || breakdown.Referrers[0] != want[0] || breakdown.Referrers[1] != want[1] {
+		t.Errorf("unexpected referrers; want %v; got %v", want, breakdown.Referrers)
+	}
+	if len(breakdown.Networks) != 1 || breakdown.Networks[0].Hits != 3 {
+		t.Errorf("all visits should come from a single network; got %v", breakdown.Networks)
+	}
+}